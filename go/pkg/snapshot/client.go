@@ -0,0 +1,200 @@
+package snapshot
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Client drives snapshots for one project without going through the CLI. Every call
+// runs with its own settings taken from Config, so Clients can be used concurrently.
+type Client struct {
+	ProjectRoot string
+	Config      Config
+	Output      io.Writer // progress and status messages (nil = discard them)
+}
+
+// NewClient loads .snapshotrc for a project root
+func NewClient(projectRoot string) (*Client, error) {
+	root, err := filepath.Abs(projectRoot)
+	if err != nil {
+		return nil, err
+	}
+	config, err := loadConfig(root)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{ProjectRoot: root, Config: config}, nil
+}
+
+// Build the engine for one call from the client's configuration
+func (c *Client) engine() (*engine, error) {
+	e := newEngine(nil, c.Output, c.Output)
+	if root, err := filepath.Abs(c.ProjectRoot); err == nil {
+		e.projectRoot = root
+	}
+	if c.Config.SnapshotsDir != "" {
+		e.snapshotsDirName = c.Config.SnapshotsDir
+	}
+	e.concurrency = c.Config.Concurrency
+	if c.Config.ManifestLimit != nil {
+		e.manifestLimit = *c.Config.ManifestLimit
+	}
+	e.markdownLog = c.Config.MarkdownLog
+	e.gzipArtifacts = c.Config.GzipArtifacts
+	if c.Config.ScanSecrets || c.Config.Redact {
+		patterns, err := compileSecretPatterns(c.Config.SecretPatterns)
+		if err != nil {
+			return nil, fmt.Errorf("invalid secret_patterns: %v", err)
+		}
+		if c.Config.ScanSecrets {
+			e.secretPatterns = patterns
+		}
+		if c.Config.Redact {
+			e.redactPatterns = patterns
+		}
+	}
+	if c.Config.MaxFileSize != "" {
+		size, err := parseByteSize(c.Config.MaxFileSize)
+		if err != nil {
+			return nil, fmt.Errorf("invalid max_file_size: %v", err)
+		}
+		e.maxFileSize = size
+	}
+	return e, nil
+}
+
+// Options used for comparisons, from the client's configuration
+func (c *Client) diffOptions() (DiffOptions, error) {
+	opts := DiffOptions{IgnoreWhitespace: c.Config.IgnoreWhitespace, IgnoreEOL: c.Config.IgnoreEOL, TrustMtime: c.Config.TrustMtime}
+	if c.Config.MaxDiffSize != "" {
+		size, err := parseByteSize(c.Config.MaxDiffSize)
+		if err != nil {
+			return opts, fmt.Errorf("invalid max_diff_size: %v", err)
+		}
+		opts.MaxDiffSize = size
+	}
+	return opts, nil
+}
+
+// SnapshotsRoot is the directory holding the project's snapshots
+func (c *Client) SnapshotsRoot() (string, error) {
+	e, err := c.engine()
+	if err != nil {
+		return "", err
+	}
+	return e.resolveSnapshotsRoot(c.ProjectRoot), nil
+}
+
+// IgnoreList returns the active ignore patterns mapped to where each came from
+func (c *Client) IgnoreList() (*IgnoreSet, error) {
+	e, err := c.engine()
+	if err != nil {
+		return nil, err
+	}
+	return e.loadIgnoreList(c.ProjectRoot, c.Config.DevMode), nil
+}
+
+// Snapshots lists existing snapshot indices in ascending order
+func (c *Client) Snapshots() ([]int, error) {
+	snapshotsRoot, err := c.SnapshotsRoot()
+	if err != nil {
+		return nil, err
+	}
+	return listSnapshotIndices(snapshotsRoot), nil
+}
+
+// Path of a snapshot's directory
+func (c *Client) snapshotPath(e *engine, index int) (string, error) {
+	snapshotsRoot := e.resolveSnapshotsRoot(c.ProjectRoot)
+	folder := findSnapshotByIndex(snapshotsRoot, index)
+	if folder == "" {
+		return "", fmt.Errorf("snapshot folder not found for index %s", padNumber(index, SNAPSHOT_INDEX_WIDTH))
+	}
+	return filepath.Join(snapshotsRoot, folder), nil
+}
+
+// Diff compares snapshot base with snapshot compare, or with the working directory when compare is 0
+func (c *Client) Diff(base, compare int) (*DiffResult, error) {
+	e, err := c.engine()
+	if err != nil {
+		return nil, err
+	}
+	opts, err := c.diffOptions()
+	if err != nil {
+		return nil, err
+	}
+	basePath, err := c.snapshotPath(e, base)
+	if err != nil {
+		return nil, err
+	}
+	comparePath := c.ProjectRoot
+	if compare != 0 {
+		if comparePath, err = c.snapshotPath(e, compare); err != nil {
+			return nil, err
+		}
+	}
+	return e.compareSnapshots(basePath, comparePath, e.loadIgnoreList(c.ProjectRoot, c.Config.DevMode), opts)
+}
+
+// Restore writes a snapshot's files back into the project; clean also deletes files it doesn't have
+func (c *Client) Restore(index int, dryRun, clean bool) error {
+	e, err := c.engine()
+	if err != nil {
+		return err
+	}
+	snapshotPath, err := c.snapshotPath(e, index)
+	if err != nil {
+		return err
+	}
+	if meta, ok := loadSnapshotMeta(snapshotPath); ok && meta.Partial {
+		clean = false
+	}
+	return e.restoreSnapshot(snapshotPath, c.ProjectRoot, e.loadIgnoreList(c.ProjectRoot, c.Config.DevMode), dryRun, clean, false, nil)
+}
+
+// CreateSnapshot captures the project under a new index and returns it. Like the CLI it checks for
+// free disk space, declines a copy of the previous snapshot and applies the configured retention.
+func (c *Client) CreateSnapshot(label string) (int, CopyStats, error) {
+	e, err := c.engine()
+	if err != nil {
+		return 0, CopyStats{}, err
+	}
+	snapshotsRoot := e.resolveSnapshotsRoot(c.ProjectRoot)
+	if err := os.MkdirAll(snapshotsRoot, 0755); err != nil {
+		return 0, CopyStats{}, err
+	}
+	request := snapshotRequest{label: label, retention: c.Config.Retention}
+	return e.createSnapshot(c.ProjectRoot, snapshotsRoot, e.loadIgnoreList(c.ProjectRoot, c.Config.DevMode), request)
+}
+
+// CompareSnapshots diffs two directory trees (a snapshot and the working directory, or two snapshots)
+func CompareSnapshots(basePath, comparePath string, ignoreSet *IgnoreSet, opts DiffOptions) (*DiffResult, error) {
+	return newEngine(nil, nil, nil).compareSnapshots(basePath, comparePath, ignoreSet, opts)
+}
+
+// CreateUnifiedDiff renders a unified diff between two file contents
+func CreateUnifiedDiff(oldContent, newContent, filename string, opts DiffOptions) string {
+	return createUnifiedDiff(oldContent, newContent, filename, opts)
+}
+
+// LoadIgnoreList reads .gitignore, .snapshotignore and the global ignore file for a project
+func LoadIgnoreList(projectRoot string, devMode bool) *IgnoreSet {
+	return newEngine(nil, nil, nil).loadIgnoreList(projectRoot, devMode)
+}
+
+// CopyDir copies a project tree into dest, skipping ignored files
+func CopyDir(src, dest string, ignoreSet *IgnoreSet) (CopyStats, error) {
+	return newEngine(nil, nil, nil).copyDir(src, dest, ignoreSet, src, nil, nil)
+}
+
+// RestoreSnapshot writes a snapshot's files into target; deleteExtra removes files not in the snapshot
+func RestoreSnapshot(snapshotPath, targetPath string, ignoreSet *IgnoreSet, dryRun, deleteExtra bool) error {
+	return newEngine(nil, nil, nil).restoreSnapshot(snapshotPath, targetPath, ignoreSet, dryRun, deleteExtra, false, nil)
+}
+
+// ReadArtifact reads a diff, prompt or regression report, decompressing .gz files written with gzip_artifacts
+func ReadArtifact(path string) ([]byte, error) {
+	return readArtifact(path)
+}
\ No newline at end of file
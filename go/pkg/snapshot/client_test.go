@@ -0,0 +1,202 @@
+package snapshot
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// A client for a fresh project that keeps its snapshots in "snaps" (set in .snapshotrc)
+func newTestClient(t *testing.T, files map[string]string) *Client {
+	t.Helper()
+	root := newTestProject(t, files)
+	writeTestFiles(t, root, map[string]string{CONFIG_FILE: `{"snapshots_dir": "snaps"}`, ".snapshotignore": "## NEVER SNAPSHOT\n*.log\n"})
+	client, err := NewClient(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+// The client's snapshots directory, failing the test on error
+func mustClientRoot(t *testing.T, client *Client) string {
+	t.Helper()
+	snapshotsRoot, err := client.SnapshotsRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return snapshotsRoot
+}
+
+func TestClientSnapshotDiffRestore(t *testing.T) {
+	client := newTestClient(t, map[string]string{"a.txt": "a\n", "b.txt": "b\n", "debug.log": "noise\n"})
+	var output bytes.Buffer
+	client.Output = &output
+	
+	if got, want := mustClientRoot(t, client), filepath.Join(client.ProjectRoot, "snaps"); got != want {
+		t.Fatalf("SnapshotsRoot() = %q, want %q from .snapshotrc", got, want)
+	}
+	ignoreSet, err := client.IgnoreList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if source := ignoreSet.Sources["*.log"]; source != IGNORE_SOURCE_NEVER {
+		t.Errorf("*.log comes from %q, want %q", source, IGNORE_SOURCE_NEVER)
+	}
+	
+	index, stats, err := client.CreateSnapshot("First Cut")
+	if err != nil || index != 1 {
+		t.Fatalf("CreateSnapshot = %d, %v", index, err)
+	}
+	// .snapshotrc, .snapshotignore, a.txt and b.txt; debug.log is ignored
+	if stats.Files != 4 {
+		t.Errorf("copied %d files, want 4", stats.Files)
+	}
+	if _, _, err := client.CreateSnapshot("again"); !errors.Is(err, ErrNoChanges) {
+		t.Errorf("unchanged CreateSnapshot err = %v, want ErrNoChanges", err)
+	}
+	
+	writeTestFiles(t, client.ProjectRoot, map[string]string{"a.txt": "changed\n", "c.txt": "c\n"})
+	if index, _, err = client.CreateSnapshot("second"); err != nil || index != 2 {
+		t.Fatalf("CreateSnapshot = %d, %v", index, err)
+	}
+	if indices, err := client.Snapshots(); err != nil || !reflect.DeepEqual(indices, []int{1, 2}) {
+		t.Errorf("Snapshots() = %v, %v", indices, err)
+	}
+	os.Remove(filepath.Join(client.ProjectRoot, "b.txt"))
+	
+	diffs := []struct {
+		name        string
+		base        int
+		compare     int
+		wantCompare string
+		want        map[string]string
+	}{
+		{"snapshot against the working directory", 1, 0, "current", map[string]string{"a.txt": "modified", "b.txt": "removed", "c.txt": "added"}},
+		{"two snapshots", 1, 2, "0002_second", map[string]string{"a.txt": "modified", "c.txt": "added"}},
+		{"snapshot against itself", 2, 2, "0002_second", map[string]string{}},
+	}
+	for _, tt := range diffs {
+		t.Run(tt.name, func(t *testing.T) {
+			diffData, err := client.Diff(tt.base, tt.compare)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make(map[string]string)
+			for _, file := range diffData.Files {
+				got[file.File] = file.Status
+			}
+			if diffData.Compare != tt.wantCompare || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Diff(%d, %d) = %s %v, want %s %v", tt.base, tt.compare, diffData.Compare, got, tt.wantCompare, tt.want)
+			}
+		})
+	}
+	if _, err := client.Diff(7, 0); err == nil {
+		t.Error("Diff of a missing snapshot should fail")
+	}
+	
+	restores := []struct {
+		name  string
+		clean bool
+		want  map[string]string
+	}{
+		{"merge", false, map[string]string{"a.txt": "a\n", "b.txt": "b\n", "c.txt": "c\n", "debug.log": "noise\n"}},
+		{"clean", true, map[string]string{"a.txt": "a\n", "b.txt": "b\n", "debug.log": "noise\n"}},
+	}
+	for _, tt := range restores {
+		t.Run("restore "+tt.name, func(t *testing.T) {
+			if err := client.Restore(1, false, tt.clean); err != nil {
+				t.Fatal(err)
+			}
+			got := readTestFiles(t, client.ProjectRoot)
+			for path := range got {
+				if path == CONFIG_FILE || path == ".snapshotignore" || strings.HasPrefix(path, "snaps/") {
+					delete(got, path)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("project holds %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if !strings.Contains(output.String(), "Restore complete") {
+		t.Errorf("Output didn't receive the status messages:\n%s", output.String())
+	}
+}
+
+func TestClientInvalidConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr string
+	}{
+		{"max_file_size", Config{MaxFileSize: "lots"}, "invalid max_file_size"},
+		{"max_diff_size", Config{MaxDiffSize: "-1"}, "invalid max_diff_size"},
+		{"secret_patterns", Config{ScanSecrets: true, SecretPatterns: []string{"("}}, "invalid secret_patterns"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestClient(t, map[string]string{"a.txt": "a\n"})
+			if _, _, err := client.CreateSnapshot("ok"); err != nil {
+				t.Fatal(err)
+			}
+			client.Config = tt.config
+			if _, err := client.Diff(1, 0); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Diff err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPackageFunctions(t *testing.T) {
+	src := t.TempDir()
+	writeTestFiles(t, src, map[string]string{
+		".snapshotignore": "## NEVER SNAPSHOT\n*.tmp\n",
+		"main.go":         "package main\n\nfunc main() {}\n",
+		"cache.tmp":       "scratch",
+	})
+	ignoreSet := LoadIgnoreList(src, false)
+	if !isIgnored("cache.tmp", ignoreSet) || isIgnored("main.go", ignoreSet) {
+		t.Fatalf("LoadIgnoreList patterns: %v", ignoreSet.Sources)
+	}
+	
+	copied := t.TempDir()
+	stats, err := CopyDir(src, copied, ignoreSet)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Files != 2 {
+		t.Errorf("CopyDir copied %d files, want 2", stats.Files)
+	}
+	
+	writeTestFiles(t, src, map[string]string{"main.go": "package main\n\nfunc main() { run() }\n"})
+	diffData, err := CompareSnapshots(copied, src, ignoreSet, DiffOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffData.Files) != 1 || diffData.Files[0].File != "main.go" || diffData.Files[0].Status != "modified" {
+		t.Fatalf("CompareSnapshots = %+v", diffData.Files)
+	}
+	if want := CreateUnifiedDiff("package main\n\nfunc main() {}\n", "package main\n\nfunc main() { run() }\n", "main.go", DiffOptions{}); diffData.Files[0].Diff != want {
+		t.Errorf("diff is %q, want CreateUnifiedDiff's %q", diffData.Files[0].Diff, want)
+	}
+	
+	if err := RestoreSnapshot(copied, src, ignoreSet, false, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := readTestFiles(t, src); got["main.go"] != "package main\n\nfunc main() {}\n" || got["cache.tmp"] != "scratch" {
+		t.Errorf("RestoreSnapshot left %v", got)
+	}
+	
+	artifact := filepath.Join(t.TempDir(), "diff.json")
+	if err := os.WriteFile(artifact, []byte(`{"files": []}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := ReadArtifact(artifact); err != nil || string(content) != `{"files": []}` {
+		t.Errorf("ReadArtifact = %q, %v", content, err)
+	}
+}
\ No newline at end of file
@@ -0,0 +1,24 @@
+//go:build windows
+
+package snapshot
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// Get the free space available to the current user on the volume containing path
+func availableDiskSpace(path string) (uint64, error) {
+	pathPtr, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+	
+	var freeBytesAvailable uint64
+	proc := syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+	ret, _, callErr := proc.Call(uintptr(unsafe.Pointer(pathPtr)), uintptr(unsafe.Pointer(&freeBytesAvailable)), 0, 0)
+	if ret == 0 {
+		return 0, callErr
+	}
+	return freeBytesAvailable, nil
+}
\ No newline at end of file
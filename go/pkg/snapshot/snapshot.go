@@ -0,0 +1,7452 @@
+package snapshot
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	SNAPSHOTS_DIR_NAME   = "__snapshots__"
+	PROMPT_TEMPLATE_FILE = ".snapshot-prompt.md"
+	SNAPSHOT_META_FILE   = ".snapshot-meta.json"
+	SNAPSHOT_INDEX_FILE  = ".snapshot-files.json"
+	CONFIG_FILE          = ".snapshotrc"
+	
+	// Minimum digits in a snapshot index; indices past 9999 simply grow wider
+	SNAPSHOT_INDEX_WIDTH = 4
+	
+	// Modified files larger than this get a size-only entry instead of a line diff
+	DEFAULT_MAX_DIFF_SIZE = 10 * 1024 * 1024
+	
+	// Minimum line similarity for a removed+added pair to count as a rename
+	RENAME_SIMILARITY_THRESHOLD = 0.8
+	
+	// Added files compared line by line against each removed file, closest in size first
+	RENAME_MAX_CANDIDATES = 20
+	
+	// Bytes of each file read by --scan-secrets; anything past this isn't scanned
+	SECRET_SCAN_LIMIT = 1024 * 1024
+	
+	// Files listed per snapshot.log section before "...and N more"
+	DEFAULT_MANIFEST_LIMIT = 10
+	
+	// Markdown copy of snapshot.log, written with --markdown-log
+	MARKDOWN_LOG_FILE = "snapshot-log.md"
+	
+	// Per-snapshot line-change stats, kept beside snapshot.log
+	MANIFEST_JSON_FILE = "manifest.json"
+	
+	// Where an ignore pattern came from
+	IGNORE_SOURCE_GITIGNORE    = ".gitignore"
+	IGNORE_SOURCE_GLOBAL       = "global ignore file"
+	IGNORE_SOURCE_GIT_EXCLUDES = "git core.excludesFile"
+	IGNORE_SOURCE_NEVER        = ".snapshotignore (NEVER SNAPSHOT)"
+	IGNORE_SOURCE_ALWAYS       = ".snapshotignore (ALWAYS SNAPSHOT)"
+	IGNORE_SOURCE_EXCEPTION    = ".snapshotignore (NEVER SNAPSHOT !exception)"
+	IGNORE_SOURCE_BUILTIN      = "built-in (snapshots directory)"
+	
+	// Files written with --encrypt start with this, then the salt and a base GCM nonce, followed by
+	// the contents sealed in chunks of ENCRYPTION_CHUNK_SIZE. Each chunk's nonce is the base nonce
+	// XORed with its number, and the last (always shorter, possibly empty) chunk is marked as final
+	// so a truncated file fails to decrypt.
+	ENCRYPTED_FILE_MAGIC      = "\x00SNAPENC1"
+	ENCRYPTION_SALT_SIZE      = 16
+	ENCRYPTION_NONCE_SIZE     = 12
+	ENCRYPTION_TAG_SIZE       = 16
+	ENCRYPTION_CHUNK_SIZE     = 64 * 1024
+	ENCRYPTION_KDF_ITERATIONS = 600000
+	
+	// Environment variable holding the --encrypt passphrase, so it needn't be typed
+	PASSPHRASE_ENV_VAR = "SNAPSHOT_PASSPHRASE"
+)
+
+// DiffFile represents a single file's change status in a diff
+type DiffFile struct {
+	File         string `json:"file"`
+	Status       string `json:"status"`
+	LinesChanged *int   `json:"lines_changed,omitempty"`
+	Insertions   *int   `json:"insertions,omitempty"`
+	Deletions    *int   `json:"deletions,omitempty"`
+	Diff         string `json:"diff,omitempty"`
+	Message      string `json:"message,omitempty"`
+	TouchCount   int    `json:"touch_count,omitempty"`
+	RenamedFrom  string `json:"renamed_from,omitempty"`
+	SizeDelta    *int64 `json:"size_delta,omitempty"` // set instead of Diff when the file is too large to diff
+}
+
+// DiffResult represents the entire comparison between snapshots
+type DiffResult struct {
+	Base    string       `json:"base"`
+	Compare string       `json:"compare"`
+	Summary *DiffSummary `json:"summary,omitempty"`
+	Files   []DiffFile   `json:"files"`
+}
+
+// DiffSummary totals a DiffResult's file entries
+type DiffSummary struct {
+	Added      int `json:"added"`
+	Modified   int `json:"modified"`
+	Removed    int `json:"removed"`
+	Renamed    int `json:"renamed"`
+	Insertions int `json:"insertions"`
+	Deletions  int `json:"deletions"`
+	Churn      int `json:"churn"` // insertions + deletions (a file's lines_changed is its line-count delta)
+}
+
+// CopyStats summarizes what copyDir wrote
+type CopyStats struct {
+	Files      int
+	Bytes      int64
+	Skipped    int                       // files over the --max-file-size limit
+	Secrets    []string                  // files left out because they matched a secret pattern (--scan-secrets)
+	Referenced int                       // unchanged files recorded as references instead of copied (--incremental)
+	Index      map[string]FileIndexEntry // slash-separated relative path -> size and hash
+	EmptyDirs  []string                  // slash-separated directories with nothing copied into them
+}
+
+// RestoreAction is one entry of restore --preview-json: Action is "restore", "skip" or "delete".
+// Empty directories that would be recreated are listed as "restore" with a trailing slash.
+type RestoreAction struct {
+	Path   string `json:"path"`
+	Action string `json:"action"`
+}
+
+// IgnoreSet maps each active ignore pattern to where it came from (see IGNORE_SOURCE_*).
+// NEVER SNAPSHOT rules also keep their position within the section (exceptions keep their "!"),
+// so a "!pattern" only re-includes what the rules above it excluded.
+type IgnoreSet struct {
+	Sources    map[string]string
+	neverOrder map[string]int
+}
+
+// Create an empty ignore set
+func newIgnoreSet() *IgnoreSet {
+	return &IgnoreSet{Sources: make(map[string]string), neverOrder: make(map[string]int)}
+}
+
+// SnapshotMeta is stored as SNAPSHOT_META_FILE inside each snapshot
+type SnapshotMeta struct {
+	Label     string    `json:"label"` // the label as typed, before sanitizeLabel
+	CreatedAt time.Time `json:"created_at"`
+	EmptyDirs []string  `json:"empty_dirs,omitempty"`      // recreated on restore
+	Partial   bool      `json:"partial,omitempty"`         // only some files were captured (--git-changed, --max-depth)
+	GitCommit string    `json:"git_commit,omitempty"`      // HEAD at snapshot time, when the project is a git repo
+	GitDirty  bool      `json:"git_dirty,omitempty"`       // the working tree had uncommitted changes
+	Parent    int       `json:"parent,omitempty"`          // snapshot this one was built on with --incremental
+	Scope     string    `json:"scope,omitempty"`           // subtree captured with --path (slash-separated, project-relative)
+	Salt      string    `json:"encryption_salt,omitempty"` // hex key-derivation salt when files were stored with --encrypt
+}
+
+// FileIndexEntry is one file in a snapshot's SNAPSHOT_INDEX_FILE
+type FileIndexEntry struct {
+	Size int64  `json:"size"`
+	Hash string `json:"sha1"`
+	Ref  int    `json:"ref,omitempty"` // index of an earlier snapshot holding the content (--incremental)
+}
+
+// macOS and Windows volumes are case-insensitive by default, so ignore
+// patterns must match regardless of case there (as git does with core.ignorecase)
+var caseInsensitiveFS = runtime.GOOS == "darwin" || runtime.GOOS == "windows"
+
+// Built-in --scan-secrets and --redact patterns
+var defaultSecretPatterns = []string{
+	`AKIA[0-9A-Z]{16}`,                   // AWS access key ID
+	`-----BEGIN [A-Z ]*PRIVATE KEY-----`, // PEM private keys
+	`gh[pousr]_[A-Za-z0-9]{36}`,          // GitHub tokens
+	`xox[abposr]-[A-Za-z0-9-]{10,}`,      // Slack tokens
+	`sk_live_[0-9A-Za-z]{24,}`,           // Stripe secret keys
+	`AIza[0-9A-Za-z_-]{35}`,              // Google API keys
+}
+
+// engine holds the settings and bookkeeping of one run (a command-line invocation or one Client
+// call), so separate runs never share state. Everything the run prints goes to out and errOut.
+type engine struct {
+	// Absolute project directory this run works on; diffs against it are labeled "current".
+	// Empty for the package-level helpers, which fall back to the process's working directory.
+	projectRoot string
+	
+	// Snapshots directory name or path; relative values resolve against the project root.
+	// Set from --snapshots-dir or SNAPSHOT_DIR, defaulting to SNAPSHOTS_DIR_NAME.
+	snapshotsDirName string
+	
+	// Files larger than this many bytes are left out of snapshots and diffs (0 = no limit).
+	// Set from --max-file-size or the max_file_size key in .snapshotrc.
+	maxFileSize int64
+	
+	// Upper bound on files copied, hashed or diffed at once (0 = GOMAXPROCS).
+	// Set from --concurrency or the concurrency key in .snapshotrc.
+	concurrency int
+	
+	// Files listed per snapshot.log section before the rest are summarized (0 = list all).
+	// Set from --manifest-limit or the manifest_limit key in .snapshotrc.
+	manifestLimit int
+	
+	// Also keep snapshot-log.md next to snapshot.log; set from --markdown-log or markdown_log in .snapshotrc
+	markdownLog bool
+	
+	// Gzip the JSON and Markdown reports written into the snapshots directory; set from
+	// --gzip-artifacts or gzip_artifacts in .snapshotrc
+	gzipArtifacts bool
+	
+	// Patterns that keep a text file out of snapshots when it matches (nil = no scanning).
+	// Set from --scan-secrets or scan_secrets in .snapshotrc; secret_patterns adds to the defaults.
+	secretPatterns []*regexp.Regexp
+	
+	// Patterns masked in generated prompts (nil = no redaction). Set from --redact or redact in
+	// .snapshotrc, using the same defaults and secret_patterns as --scan-secrets.
+	redactPatterns []*regexp.Regexp
+	
+	// Key and salt that new snapshot files are sealed with (nil = store plaintext); set by --encrypt
+	encryptionKey, encryptionSalt []byte
+	
+	// Passphrase for encrypted snapshots, from PASSPHRASE_ENV_VAR or asked for on first use.
+	// Derived keys are cached by salt, since derivation is deliberately slow.
+	encryptionPassphrase string
+	derivedKeys          map[string][]byte
+	
+	// Encrypted snapshot directories unlocked so far; only files inside them are ever decrypted
+	sealedSnapshots map[string]bool
+	encryptionMu    sync.Mutex
+	
+	// Match labels case-sensitively in find and label references; set from --exact-case
+	exactCase bool
+	
+	// Guards CopyStats while files are copied in parallel
+	copyStatsMu sync.Mutex
+	
+	// Deepest directory level to include, counted from the project root (-1 = no limit).
+	// Depth 0 keeps only files in the root itself. Set from --max-depth.
+	maxDepth int
+	
+	// Leave .gitignore out of the ignore set, relying on .snapshotignore alone; set from --no-gitignore
+	noGitignore bool
+	
+	// Copy what symlinks point to instead of skipping them; set from --follow-symlinks
+	followSymlinks bool
+	
+	// Print extra detail (such as skipped files) while snapshotting; set from --verbose
+	verbose bool
+	
+	// Suppress progress output; set from --quiet
+	quietMode bool
+	
+	// Turn unreadable files into a hard failure instead of skipping them; set from --strict
+	strictMode bool
+	
+	// Files that could not be read, keyed by path and reported once at the end of the run
+	fileErrors   map[string]error
+	fileErrorsMu sync.Mutex
+	
+	// Walk warnings already printed during this run (both walkers see the same paths)
+	walkWarned   map[string]bool
+	walkWarnedMu sync.Mutex
+	
+	// Input for prompts, buffered once so input isn't lost between successive prompts
+	stdin       io.Reader
+	stdinReader *bufio.Reader
+	
+	// Whether stdin is a terminal someone can answer prompts on
+	interactive bool
+	
+	out, errOut io.Writer
+}
+
+// Create an engine with default settings; nil writers discard output and a nil stdin reads nothing
+func newEngine(stdin io.Reader, out, errOut io.Writer) *engine {
+	if stdin == nil {
+		stdin = strings.NewReader("")
+	}
+	if out == nil {
+		out = io.Discard
+	}
+	if errOut == nil {
+		errOut = io.Discard
+	}
+	return &engine{
+		snapshotsDirName: SNAPSHOTS_DIR_NAME,
+		manifestLimit:    DEFAULT_MANIFEST_LIMIT,
+		maxDepth:         -1,
+		derivedKeys:      make(map[string][]byte),
+		sealedSnapshots:  make(map[string]bool),
+		fileErrors:       make(map[string]error),
+		walkWarned:       make(map[string]bool),
+		stdin:            stdin,
+		stdinReader:      bufio.NewReader(stdin),
+		interactive:      isTerminal(stdin),
+		out:              out,
+		errOut:           errOut,
+	}
+}
+
+// Record a file that could not be read; returns the error only in --strict mode
+func (e *engine) recordFileError(path string, err error) error {
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		err = pathErr.Err
+	}
+	
+	e.fileErrorsMu.Lock()
+	defer e.fileErrorsMu.Unlock()
+	if _, seen := e.fileErrors[path]; !seen {
+		e.fileErrors[path] = err
+	}
+	if e.strictMode {
+		return fmt.Errorf("%s: %v (--strict)", path, err)
+	}
+	return nil
+}
+
+// Summarize files skipped because of read errors
+func (e *engine) printFileErrorSummary() {
+	e.fileErrorsMu.Lock()
+	defer e.fileErrorsMu.Unlock()
+	if len(e.fileErrors) == 0 {
+		return
+	}
+	
+	var paths []string
+	for path := range e.fileErrors {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	
+	fmt.Fprintf(e.errOut, "⚠️  %d file%s skipped due to errors (use --strict to fail instead):\n", len(paths), plural(len(paths)))
+	for _, path := range paths {
+		fmt.Fprintf(e.errOut, "   %s: %v\n", path, e.fileErrors[path])
+	}
+}
+
+// Config holds project defaults loaded from .snapshotrc (command-line flags win)
+type Config struct {
+	SnapshotsDir     string           `json:"snapshots_dir"`
+	DevMode          bool             `json:"dev_mode"`
+	MaxTokens        int              `json:"max_tokens"`
+	Template         string           `json:"template"`
+	IgnoreWhitespace bool             `json:"ignore_whitespace"`
+	IgnoreEOL        bool             `json:"ignore_eol"`
+	TrustMtime       bool             `json:"trust_mtime"`
+	MaxDiffSize      string           `json:"max_diff_size"`
+	NoInteractive    bool             `json:"no_interactive"`
+	MaxFileSize      string           `json:"max_file_size"`
+	Concurrency      int              `json:"concurrency"`
+	ManifestLimit    *int             `json:"manifest_limit"` // nil = DEFAULT_MANIFEST_LIMIT, 0 = no limit
+	MarkdownLog      bool             `json:"markdown_log"`
+	GzipArtifacts    bool             `json:"gzip_artifacts"`
+	Retention        *RetentionPolicy `json:"retention"` // nil = never prune automatically
+	ScanSecrets      bool             `json:"scan_secrets"`
+	SecretPatterns   []string         `json:"secret_patterns"` // extra regexes for scan_secrets and redact
+	Redact           bool             `json:"redact"`
+}
+
+// RetentionPolicy prunes old snapshots after each new one: the newest KeepLast are always kept,
+// and older ones are thinned to the newest snapshot of each of the KeepDaily most recent days
+type RetentionPolicy struct {
+	KeepLast  int `json:"keep_last"`
+	KeepDaily int `json:"keep_daily"`
+}
+
+// DiffOptions controls how file contents are compared
+type DiffOptions struct {
+	IgnoreWhitespace bool
+	IgnoreEOL        bool  // Treat CRLF and LF line endings as equal
+	TrustMtime       bool  // Treat same-size files with identical mtimes as unchanged
+	MaxDiffSize      int64 // Skip line diffs of files above this many bytes (0 = DEFAULT_MAX_DIFF_SIZE)
+	CompareManifest  bool  // Compare file lists and indexed hashes only, never opening files
+	// Called with each entry as soon as it is final, one call at a time and in path order. When both added
+	// and removed files exist they may pair up as renames, so those entries (and the renames) follow the
+	// rest once rename detection has run. Streamed modified entries are kept without their Diff text.
+	// An error from Stream stops the comparison.
+	Stream func(DiffFile) error
+}
+
+// Helper function to ask user for input
+func (e *engine) askUser(query string) (string, error) {
+	fmt.Fprint(e.out, query)
+	answer, err := e.stdinReader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(answer), nil
+}
+
+// Sanitize labels: lowercase, replace spaces, strip unsafe chars.
+// Unicode letters and digits are kept; a label with nothing usable left
+// (e.g. only emoji) falls back to a timestamp so folders never end in "_".
+func sanitizeLabel(label string) string {
+	label = strings.ToLower(strings.TrimSpace(label))
+	label = regexp.MustCompile(`\s+`).ReplaceAllString(label, "_")
+	label = regexp.MustCompile(`[^\p{L}\p{N}._-]`).ReplaceAllString(label, "")
+	label = strings.Trim(label, "_")
+	if label == "" {
+		label = "snapshot_" + time.Now().Format("20060102_150405")
+	}
+	return label
+}
+
+// Pad number with zeros to at least width digits
+func padNumber(num int, width int) string {
+	return fmt.Sprintf("%0*d", width, num)
+}
+
+// Snapshot folders are "<index>_<label>". The tool writes 4-digit zero-padded indices,
+// but hand-made folders such as "12_foo" are read as the same index 12.
+var snapshotFolderPattern = regexp.MustCompile(`^(\d+)_(.*)$`)
+
+// Split a snapshot folder name into its index and sanitized label
+func parseSnapshotFolder(name string) (int, string, bool) {
+	matches := snapshotFolderPattern.FindStringSubmatch(name)
+	if matches == nil {
+		return 0, "", false
+	}
+	index, err := strconv.Atoi(matches[1])
+	if err != nil {
+		return 0, "", false
+	}
+	return index, matches[2], true
+}
+
+// The sanitized label part of a snapshot folder name
+func snapshotFolderLabel(folder string) string {
+	if _, label, ok := parseSnapshotFolder(folder); ok {
+		return label
+	}
+	return folder
+}
+
+// Get next snapshot index
+func getNextSnapshotIndex(snapshotPath string) int {
+	indices := listSnapshotIndices(snapshotPath)
+	if len(indices) == 0 {
+		return 1
+	}
+	return indices[len(indices)-1] + 1
+}
+
+// List existing snapshot indices in ascending order (an index shared by two folders is listed once)
+func listSnapshotIndices(snapshotsRoot string) []int {
+	dirs, err := os.ReadDir(snapshotsRoot)
+	if err != nil {
+		return nil
+	}
+	
+	seen := make(map[int]bool)
+	var indices []int
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		if index, _, ok := parseSnapshotFolder(dir.Name()); ok && !seen[index] {
+			seen[index] = true
+			indices = append(indices, index)
+		}
+	}
+	sort.Ints(indices)
+	return indices
+}
+
+// Find snapshot by index, comparing numerically so "12_foo" and "0012_foo" both match 12.
+// If both exist, the zero-padded folder wins.
+func findSnapshotByIndex(snapshotsRoot string, targetIndex int) string {
+	// Index prefixes are pure digits, so matching is unaffected by case folding
+	paddedIndex := padNumber(targetIndex, SNAPSHOT_INDEX_WIDTH)
+	dirs, err := os.ReadDir(snapshotsRoot)
+	if err != nil {
+		return ""
+	}
+	
+	fallback := ""
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		if index, _, ok := parseSnapshotFolder(dir.Name()); ok && index == targetIndex {
+			if strings.HasPrefix(dir.Name(), paddedIndex+"_") {
+				return dir.Name()
+			}
+			if fallback == "" {
+				fallback = dir.Name()
+			}
+		}
+	}
+	return fallback
+}
+
+// Show comprehensive help
+func (e *engine) showHelp() {
+	fmt.Fprintln(e.out, "")
+	fmt.Fprintln(e.out, "📸 jw-ai-snapshot - Lightweight AI-assisted development snapshotting tool")
+	fmt.Fprintln(e.out, "")
+	fmt.Fprintln(e.out, "USAGE:")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 init                       Initialize project configuration")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 \"description\"              Create a new snapshot")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 \"description\" --dev-mode   Create snapshot including tool files")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 [options] -- init          Use a command word (init, log, show...) as the label;")
+	fmt.Fprintln(e.out, "                                          everything after -- is the label, so put options first")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 \"description\" --force      Create snapshot even if nothing changed")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 \"description\" --allow-empty Create snapshot even if no files are included")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 \"description\" --git-changed  Snapshot only files git reports as modified/staged/untracked")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 \"description\" --incremental  Store only files changed since the previous snapshot")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 \"description\" --dry-run    List the files a snapshot would include without creating it")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 \"description\" --max-file-size 10MB  Skip files larger than 10MB")
+	fmt.Fprintln(e.out, "  git log -1 --format=%B | ./snapshot_v2 --stdin-label  Read a multi-line description from stdin (or use \"-\")")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 \"description\" --path DIR  Snapshot only the DIR subtree (diff and restore stay inside it)")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 \"description\" --force-index N  Create the snapshot as number N (for scripted fixtures;")
+	fmt.Fprintln(e.out, "                                          normal use never needs it; --force overwrites an existing N)")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 NNNN --diff               Compare snapshot to current")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 NNNN MMMM --diff          Compare two snapshots")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 NNNN --diff --html        Also write a color-coded HTML report")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 NNNN --diff --stat        Print a per-file insertions/deletions summary")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 NNNN --diff --name-only   Print only changed paths (--name-status adds A/M/D/R)")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 NNNN --diff --json-lines  Stream one JSON object per changed file, in path order, as each is compared")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 NNNN --diff --only-status removed  Keep only some statuses (comma list or repeat; A,M,D,R work too)")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 NNNN --prompt --open      Open the generated file in $EDITOR (also works with --diff)")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 NNNN --diff --no-save     Don't write the diff JSON file")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 NNNN --diff --patch       Also write a .patch file for git apply / patch -p1")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 NNNN --diff --against DIR Compare a snapshot against another directory")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 NNNN MMMM --range         Summarize files touched across a snapshot range")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 NNNN --prompt             Generate AI analysis prompt")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 NNNN MMMM --prompt        Generate AI analysis prompt between two snapshots")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 5 8 11 --prompt           One prompt with a section per snapshot, each diffed to current")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 NNNN --restore            Restore snapshot files (files not in the snapshot are kept)")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 NNNN --restore --clean    Mirror the snapshot exactly, deleting files it doesn't have")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 NNNN --restore --dry-run  Preview restore changes")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 NNNN --restore --dry-run --show-diff  Also print the diff each overwritten file would get")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 NNNN --restore --preview-json  Print what a restore would do as JSON [{path, action}] (no changes)")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 NNNN --restore --into DIR Extract a snapshot into DIR (nothing is deleted)")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 NNNN --analyze-regression Advanced regression analysis")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 NNNN MMMM --analyze-regression  Same, with MMMM as the first broken snapshot")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 bisect GOOD BAD           Find the first bad snapshot by checking extracted midpoints")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 rename NNNN \"label\"       Relabel an existing snapshot")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 amend                     Replace the latest snapshot with the working tree")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 find \"text\"              List snapshots whose label contains text (any case; see --exact-case)")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 prune --keep N            Delete all but the newest N snapshots")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 prune --older-than 30d    Delete snapshots older than 30 days (combine with --keep)")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 log [--oneline] [-n N]    Show the change manifest, newest first")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 show NNNN <path>          Print a colorized diff of one file against the snapshot")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 export NNNN <file.tar.gz> Pack a snapshot into a standalone archive")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 import <file.tar.gz>      Add an exported archive as the next snapshot")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 history <path>            Trace one file across every snapshot")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 rebuild-log               Regenerate snapshot.log from the snapshots on disk")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 gc [--dry-run]            Delete diff/prompt/regression files for snapshots that no longer exist")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 verify NNNN               Re-hash a snapshot and report damaged or missing files")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 --list                    List snapshots (add --since 2d / --before 1w to filter)")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 status                    Show drift from the latest snapshot (exit 1 if changed)")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 check-ignore              Validate .snapshotignore headers and patterns")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 check-ignore <path>       Explain which pattern includes or excludes a path")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 doctor                    Cross-check .gitignore, .snapshotignore and the tree (exits 1 on contradictions)")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 --help                    Show this help")
+	fmt.Fprintln(e.out, "")
+	fmt.Fprintln(e.out, "EXAMPLES:")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 init                       # First-time setup for new project")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 \"working login feature\"   # Create snapshot 0001_working_login_feature")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 23 --diff                 # Compare snapshot 23 to current state")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 20 25 --diff              # Compare snapshot 20 to snapshot 25")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 15 --prompt               # Generate AI prompt for changes since snapshot 15")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 18 --restore --dry-run    # Preview what restoring snapshot 18 would do")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 10 --analyze-regression   # Advanced analysis: find what broke after snapshot 10")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 latest --diff             # Compare the newest snapshot to current state")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 -1 latest --diff          # Compare the snapshot before the newest to the newest")
+	fmt.Fprintln(e.out, "  ./snapshot_v2 Login --diff              # Refer to a snapshot by its label (add --exact-case to match case)")
+	fmt.Fprintln(e.out, "")
+	fmt.Fprintln(e.out, "GETTING STARTED:")
+	fmt.Fprintln(e.out, "  1. 🚀 Run \"./snapshot_v2 init\" in your project directory")
+	fmt.Fprintln(e.out, "  2. 📸 Take snapshots frequently during development")
+	fmt.Fprintln(e.out, "  3. 🔍 When something breaks, compare snapshots to identify changes")
+	fmt.Fprintln(e.out, "  4. 🤖 Use --prompt or --analyze-regression to get AI-ready analysis")
+	fmt.Fprintln(e.out, "  5. 🔧 Fix the issue using AI insights")
+	fmt.Fprintln(e.out, "  6. ♻️  Use --restore if you need to rollback to a working state")
+	fmt.Fprintln(e.out, "")
+	fmt.Fprintln(e.out, "SNAPSHOT STORAGE:")
+	fmt.Fprintln(e.out, "  Snapshots are stored in __snapshots__/ directory with format: NNNN_description/")
+	fmt.Fprintln(e.out, "  Use --snapshots-dir PATH (or SNAPSHOT_DIR) to store them elsewhere, e.g. on another volume")
+	fmt.Fprintln(e.out, "  Configure exclusions using .snapshotignore (two-section format)")
+	fmt.Fprintln(e.out, "  Patterns in ~/.config/snapshot/ignore (or $XDG_CONFIG_HOME/snapshot/ignore) apply to every project")
+	fmt.Fprintln(e.out, "  Set defaults in .snapshotrc (JSON): snapshots_dir, dev_mode, max_tokens, template,")
+	fmt.Fprintln(e.out, "  ignore_whitespace, ignore_eol, trust_mtime, no_interactive, max_file_size, max_diff_size, concurrency,")
+	fmt.Fprintln(e.out, "  manifest_limit, markdown_log, gzip_artifacts, retention, scan_secrets, secret_patterns,")
+	fmt.Fprintln(e.out, "  redact")
+	fmt.Fprintln(e.out, "  (command-line flags take precedence)")
+	fmt.Fprintln(e.out, "  \"retention\": {\"keep_last\": 20, \"keep_daily\": 7} prunes after every snapshot: the newest 20 stay,")
+	fmt.Fprintln(e.out, "  older ones are thinned to one per day for the 7 most recent days")
+	fmt.Fprintln(e.out, "  --max-file-size SIZE leaves out files above SIZE (KB/MB/GB) from snapshots and diffs")
+	fmt.Fprintln(e.out, "  --max-diff-size SIZE reports only the size change for modified files above SIZE (default 10MB)")
+	fmt.Fprintln(e.out, "  --max-depth N only descends N directory levels (0 = root files only) when snapshotting and diffing")
+	fmt.Fprintln(e.out, "  --concurrency N caps parallel file reads/writes (default: CPU count); use 1 on slow or network disks")
+	fmt.Fprintln(e.out, "  --manifest-limit N lists N files per snapshot.log section before \"...and N more\" (default 10, 0 = all)")
+	fmt.Fprintln(e.out, "  --markdown-log also appends each entry to snapshot-log.md")
+	fmt.Fprintln(e.out, "  --encrypt stores file contents with AES-GCM under a passphrase (SNAPSHOT_PASSPHRASE or prompted);")
+	fmt.Fprintln(e.out, "  diff, restore and verify ask for it again when they read an encrypted snapshot")
+	fmt.Fprintln(e.out, "  --scan-secrets leaves out text files matching secret patterns (AWS keys, private keys, tokens);")
+	fmt.Fprintln(e.out, "  add your own regexes with secret_patterns in .snapshotrc")
+	fmt.Fprintln(e.out, "  --redact masks values matching the same patterns with **** in generated prompts")
+	fmt.Fprintln(e.out, "  --gzip-artifacts writes diff/prompt/regression JSON and Markdown as .gz files (gc understands both)")
+	fmt.Fprintln(e.out, "  --verbose (-v) lists each skipped file while snapshotting")
+	fmt.Fprintln(e.out, "  Symlinks are skipped; --follow-symlinks copies what they point to (link loops are not followed)")
+	fmt.Fprintln(e.out, "  Unreadable files are skipped with a warning; --strict makes them a hard failure")
+	fmt.Fprintln(e.out, "  • ALWAYS SNAPSHOT: Override .gitignore to include specific files (globs such as build/*.wasm work too)")
+	fmt.Fprintln(e.out, "  • NEVER SNAPSHOT: Add snapshot-specific exclusions")
+	fmt.Fprintln(e.out, "  Patterns may end in a # comment; write \\# for a literal hash in a filename")
+	fmt.Fprintln(e.out, "  In NEVER SNAPSHOT, !pattern re-includes what the lines above it excluded (logs/ then !logs/keep.txt)")
+	fmt.Fprintln(e.out, "  git's core.excludesFile (from .git/config or ~/.gitconfig) is applied beneath .gitignore")
+	fmt.Fprintln(e.out, "  --no-gitignore ignores .gitignore entirely; only .snapshotignore's NEVER section excludes files")
+	fmt.Fprintln(e.out, "")
+	fmt.Fprintln(e.out, "AI FEATURES:")
+	fmt.Fprintln(e.out, "  --prompt:             Generate single-comparison analysis (NNNN vs current)")
+	fmt.Fprintln(e.out, "  --max-tokens N:       Keep --prompt under ~N tokens (chars/4) by truncating the largest diffs first")
+	fmt.Fprintln(e.out, "  --analyze-regression: Advanced two-part analysis (NNNN vs NNNN+1, or a given MMMM, vs current)")
+	fmt.Fprintln(e.out, "                       Perfect for finding when and why something broke")
+	fmt.Fprintln(e.out, "  --summary-only:       With --analyze-regression, list Section 2 files with line counts instead of full diffs")
+	fmt.Fprintln(e.out, "  --template PATH:      Use a custom prompt template (default: .snapshot-prompt.md if present)")
+	fmt.Fprintln(e.out, "                       Placeholders: {{BASE}} {{COMPARE}} {{REMOVED}} {{ADDED}} {{MODIFIED}} {{RENAMED}}")
+	fmt.Fprintln(e.out, "                       (--analyze-regression also fills {{CUMULATIVE}})")
+	fmt.Fprintln(e.out, "")
+	fmt.Fprintln(e.out, "DIFF OPTIONS:")
+	fmt.Fprintln(e.out, "  --ignore-whitespace: Treat whitespace-only changes (indentation, trailing spaces) as unchanged")
+	fmt.Fprintln(e.out, "  --ignore-eol:        Treat CRLF and LF line endings as the same")
+	fmt.Fprintln(e.out, "  --trust-mtime:       Skip hashing files whose size and modification time are unchanged")
+	fmt.Fprintln(e.out, "  --compare-manifest:  Between two snapshots, compare file lists and stored hashes only (no line counts)")
+	fmt.Fprintln(e.out, "  --diff prints a colored per-file summary (paged through $PAGER on a terminal; NO_COLOR disables color)")
+	fmt.Fprintln(e.out, "")
+	fmt.Fprintln(e.out, "SCRIPTING:")
+	fmt.Fprintln(e.out, "  --no-interactive:    Never prompt to pick a snapshot when --diff/--prompt/--restore has no index")
+	fmt.Fprintln(e.out, "  --quiet (-q):        Hide progress output (it is also hidden when stdout isn't a terminal)")
+	fmt.Fprintln(e.out, "")
+	fmt.Fprintln(e.out, "DEVELOPER OPTIONS:")
+	fmt.Fprintln(e.out, "  --dev-mode:          Include tool source files (snapshot_v2.go, go.mod, etc.)")
+	fmt.Fprintln(e.out, "                       Useful for taking snapshots of the tool itself during development")
+	fmt.Fprintln(e.out, "")
+}
+
+// Initialize project with .snapshotignore and .gitignore setup
+func (e *engine) initializeProject(projectRoot string) error {
+	snapshotignorePath := filepath.Join(projectRoot, ".snapshotignore")
+	
+	// Check if already initialized
+	if _, err := os.Stat(snapshotignorePath); err == nil {
+		fmt.Fprintln(e.out, "")
+		fmt.Fprintln(e.out, "⚠️  Project already initialized!")
+		fmt.Fprintln(e.out, "   .snapshotignore file already exists.")
+		fmt.Fprintln(e.out, "   To reconfigure, edit the .snapshotignore file directly.")
+		fmt.Fprintln(e.out, "")
+		return nil
+	}
+	
+	fmt.Fprintln(e.out, "")
+	fmt.Fprintln(e.out, "🚀 Welcome to jw-ai-snapshot!")
+	fmt.Fprintln(e.out, "   Setting up your project configuration...")
+	fmt.Fprintln(e.out, "")
+	
+	// Question: Add __snapshots__/ to .gitignore?
+	fmt.Fprintln(e.out, "❓ Add the '__snapshots__/' directory to your .gitignore file?")
+	fmt.Fprintln(e.out, "   (Recommended - prevents committing local snapshots to your repository)")
+	
+	var addToGitignore string
+	for !contains([]string{"y", "n", "yes", "no"}, strings.ToLower(addToGitignore)) {
+		answer, err := e.askUser("   Add __snapshots__/ to .gitignore? (Y/n): ")
+		if err != nil {
+			return err
+		}
+		if answer == "" {
+			answer = "y" // Default to yes
+		}
+		addToGitignore = answer
+		if !contains([]string{"y", "n", "yes", "no"}, strings.ToLower(addToGitignore)) {
+			fmt.Fprintln(e.out, "   Please enter y, n, yes, or no.")
+		}
+	}
+	shouldAddToGitignore := contains([]string{"y", "yes"}, strings.ToLower(addToGitignore))
+	
+	// Question: Scaffold a .snapshotrc defaults file?
+	fmt.Fprintln(e.out, "")
+	fmt.Fprintln(e.out, "❓ Create a .snapshotrc file for default options (snapshots directory, diff flags, etc.)?")
+	fmt.Fprintln(e.out, "   (Optional - command-line flags always override it)")
+	
+	var createConfig string
+	for !contains([]string{"y", "n", "yes", "no"}, strings.ToLower(createConfig)) {
+		// Scripts that pipe in only the .gitignore answer (echo y | snapshot init) get the default
+		answer, err := e.askUser("   Create .snapshotrc? (y/N): ")
+		if err == io.EOF {
+			fmt.Fprintln(e.out)
+		} else if err != nil {
+			return err
+		}
+		if answer == "" {
+			answer = "n" // Default to no
+		}
+		createConfig = answer
+		if !contains([]string{"y", "n", "yes", "no"}, strings.ToLower(createConfig)) {
+			fmt.Fprintln(e.out, "   Please enter y, n, yes, or no.")
+		}
+	}
+	shouldCreateConfig := contains([]string{"y", "yes"}, strings.ToLower(createConfig))
+	
+	fmt.Fprintln(e.out, "")
+	fmt.Fprintln(e.out, "📝 Creating configuration...")
+	
+	// Create .snapshotignore file with new two-section format
+	configLines := []string{
+		"# jw-ai-snapshot Configuration File",
+		"# This file works WITH your .gitignore, not against it. The snapshot tool",
+		"# will always use your .gitignore rules as a base. This file lets you",
+		"# manage the exceptions.",
+		"",
+		"#-----------------------------------------------------------------------",
+		"## ALWAYS SNAPSHOT (Exceptions to .gitignore)",
+		"#-----------------------------------------------------------------------",
+		"# Add files or folders here that you WANT to include in snapshots,",
+		"# even if your .gitignore file ignores them.",
+		"#",
+		"# COMMON USE CASE: Your .gitignore probably ignores 'build/' or 'dist/'.",
+		"# Add 'build/' here to make sure your snapshots contain those critical",
+		"# build artifacts, creating a fully working copy.",
+		"#",
+		"# ANOTHER USE CASE: To snapshot environment files, add '.env' here.",
+		"",
+		"# build/",
+		"# .env",
+		"",
+		"",
+		"#-----------------------------------------------------------------------",
+		"## NEVER SNAPSHOT (Snapshot-specific ignores)",
+		"#-----------------------------------------------------------------------",
+		"# Add files or folders here that should ONLY be ignored for snapshots.",
+		"# This is useful for large assets or logs that you track in git but",
+		"# don't need in every quick code snapshot.",
+		"",
+		"# --- Default Safe Ignores ---",
+		"",
+		"# Version Control",
+		".git/",
+		"",
+		"# Dependencies",
+		"node_modules/",
+		"",
+		"# OS & Editor specific",
+		".DS_Store",
+		".vscode/",
+		".idea/",
+		"",
+		"# Logs",
+		"*.log",
+		"",
+		"# Environment Files (un-comment the lines below to ignore them)",
+		".env",
+		".env.local",
+		"",
+	}
+	
+	err := os.WriteFile(snapshotignorePath, []byte(strings.Join(configLines, "\n")), 0644)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(e.out, "✅ Created .snapshotignore with two-section configuration.")
+	
+	// Add to .gitignore if requested
+	if shouldAddToGitignore {
+		gitignorePath := filepath.Join(projectRoot, ".gitignore")
+		gitignoreEntry := "__snapshots__/"
+		
+		if _, err := os.Stat(gitignorePath); err == nil {
+			gitignoreContent, err := os.ReadFile(gitignorePath)
+			if err != nil {
+				return err
+			}
+			if !strings.Contains(string(gitignoreContent), gitignoreEntry) {
+				newContent := string(gitignoreContent)
+				if !strings.HasSuffix(newContent, "\n") {
+					newContent += "\n"
+				}
+				newContent += "\n# jw-ai-snapshot: Ignore local snapshots directory\n" + gitignoreEntry + "\n"
+				err = os.WriteFile(gitignorePath, []byte(newContent), 0644)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintln(e.out, "✅ Added '__snapshots__/' to .gitignore.")
+			} else {
+				fmt.Fprintln(e.out, "ℹ️  '__snapshots__/' already exists in .gitignore.")
+			}
+		} else {
+			newContent := "# jw-ai-snapshot: Ignore local snapshots directory\n" + gitignoreEntry + "\n"
+			err = os.WriteFile(gitignorePath, []byte(newContent), 0644)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(e.out, "✅ Created .gitignore and added '__snapshots__/'.")
+		}
+	}
+	
+	// Scaffold .snapshotrc with every option at its default
+	if shouldCreateConfig {
+		configPath := filepath.Join(projectRoot, CONFIG_FILE)
+		if _, err := os.Stat(configPath); err == nil {
+			fmt.Fprintln(e.out, "ℹ️  .snapshotrc already exists.")
+		} else {
+			configJSON, _ := json.MarshalIndent(Config{SnapshotsDir: SNAPSHOTS_DIR_NAME}, "", "  ")
+			if err := os.WriteFile(configPath, append(configJSON, '\n'), 0644); err != nil {
+				return err
+			}
+			fmt.Fprintln(e.out, "✅ Created .snapshotrc with default options.")
+		}
+	}
+	
+	fmt.Fprintln(e.out, "")
+	fmt.Fprintln(e.out, "🎉 Project initialized successfully!")
+	fmt.Fprintln(e.out, "   The .snapshotignore file uses two sections:")
+	fmt.Fprintln(e.out, "   • ALWAYS SNAPSHOT: Override .gitignore to include specific files")
+	fmt.Fprintln(e.out, "   • NEVER SNAPSHOT: Add snapshot-specific exclusions")
+	fmt.Fprintln(e.out, "")
+	fmt.Fprintln(e.out, "   You can now create your first snapshot:")
+	fmt.Fprintln(e.out, "   ./snapshot_v2 \"initial version\"")
+	fmt.Fprintln(e.out, "")
+	
+	return nil
+}
+
+// Load project defaults from .snapshotrc (a missing file yields an empty config)
+func loadConfig(projectRoot string) (Config, error) {
+	var config Config
+	content, err := os.ReadFile(filepath.Join(projectRoot, CONFIG_FILE))
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return config, err
+	}
+	if err := json.Unmarshal(content, &config); err != nil {
+		return config, fmt.Errorf("invalid %s: %v", CONFIG_FILE, err)
+	}
+	return config, nil
+}
+
+// snapshotignoreEntry is a pattern (or unrecognized header) from .snapshotignore
+type snapshotignoreEntry struct {
+	Pattern string
+	Section string // "always", "never", or "" when no header precedes it
+	Line    int
+}
+
+// Parse .snapshotignore into section-tagged patterns plus any unrecognized "##" headers
+func parseSnapshotignore(content string) ([]snapshotignoreEntry, []snapshotignoreEntry) {
+	var entries, unknownHeaders []snapshotignoreEntry
+	currentSection := ""
+	
+	for i, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		
+		// Skip empty lines and comments (unless they're section headers)
+		if trimmed == "" || (strings.HasPrefix(trimmed, "#") && !strings.HasPrefix(trimmed, "##")) {
+			continue
+		}
+		
+		// Check for section headers
+		if trimmed == "## ALWAYS SNAPSHOT (Exceptions to .gitignore)" || strings.Contains(trimmed, "## ALWAYS SNAPSHOT") {
+			currentSection = "always"
+			continue
+		}
+		if trimmed == "## NEVER SNAPSHOT (Snapshot-specific ignores)" || strings.Contains(trimmed, "## NEVER SNAPSHOT") {
+			currentSection = "never"
+			continue
+		}
+		
+		// Any other "##" line looks like a mistyped header
+		if strings.HasPrefix(trimmed, "##") {
+			unknownHeaders = append(unknownHeaders, snapshotignoreEntry{Pattern: trimmed, Line: i + 1})
+			continue
+		}
+		
+		// Skip commented patterns
+		if strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		
+		pattern := stripInlineComment(trimmed)
+		if pattern == "" {
+			continue
+		}
+		
+		entries = append(entries, snapshotignoreEntry{
+			Pattern: strings.TrimRight(normalizePatternSeparators(pattern), "/"),
+			Section: currentSection,
+			Line:    i + 1,
+		})
+	}
+	
+	return entries, unknownHeaders
+}
+
+// Drop a trailing "# comment" from a pattern line; "\#" stands for a literal hash
+func stripInlineComment(line string) string {
+	var pattern strings.Builder
+	for i := 0; i < len(line); i++ {
+		if line[i] == '\\' && i+1 < len(line) && line[i+1] == '#' {
+			pattern.WriteByte('#')
+			i++
+			continue
+		}
+		if line[i] == '#' {
+			break
+		}
+		pattern.WriteByte(line[i])
+	}
+	return strings.TrimSpace(pattern.String())
+}
+
+// Write an ignore pattern with forward slashes, so a Windows-style src\foo matches like src/foo
+// (paths are compared in slash form too). A backslash escaping a glob or special character
+// such as \* or \! is kept.
+func normalizePatternSeparators(pattern string) string {
+	var normalized strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] == '\\' && (i+1 >= len(pattern) || !strings.ContainsRune("*?[]!# \\", rune(pattern[i+1]))) {
+			normalized.WriteByte('/')
+			continue
+		}
+		if pattern[i] == '\\' {
+			normalized.WriteByte(pattern[i])
+			i++
+		}
+		normalized.WriteByte(pattern[i])
+	}
+	return normalized.String()
+}
+
+// Validate .snapshotignore, returning structural problems and patterns that match nothing
+func (e *engine) validateSnapshotignore(projectRoot string) ([]string, []string, error) {
+	content, err := os.ReadFile(filepath.Join(projectRoot, ".snapshotignore"))
+	if err != nil {
+		return nil, nil, err
+	}
+	entries, unknownHeaders := parseSnapshotignore(string(content))
+	
+	var problems, unmatched []string
+	for _, header := range unknownHeaders {
+		problems = append(problems, fmt.Sprintf("line %d: unrecognized section header %q (expected \"## ALWAYS SNAPSHOT\" or \"## NEVER SNAPSHOT\")", header.Line, header.Pattern))
+	}
+	
+	// Walk the whole tree (minus the snapshots directory) to find dead patterns
+	allFiles, err := e.listFilesRecursively(projectRoot, projectRoot, newIgnoreSet())
+	if err != nil {
+		return problems, nil, err
+	}
+	
+	for _, entry := range entries {
+		if entry.Section == "" {
+			problems = append(problems, fmt.Sprintf("line %d: pattern %q appears before any section header and is ignored", entry.Line, entry.Pattern))
+			continue
+		}
+		
+		single := newIgnoreSet()
+		single.Sources[strings.TrimPrefix(entry.Pattern, "!")] = IGNORE_SOURCE_NEVER
+		matched := false
+		for _, file := range allFiles {
+			if isIgnored(file, single) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			unmatched = append(unmatched, fmt.Sprintf("line %d: pattern %q does not match any file in the project", entry.Line, entry.Pattern))
+		}
+	}
+	
+	return problems, unmatched, nil
+}
+
+// Cross-check the ignore rules against the tree: files a gitignore-style source excludes but
+// ALWAYS SNAPSHOT re-includes, and files matched by both ALWAYS and NEVER SNAPSHOT
+// (NEVER wins, which is rarely what was meant)
+func (e *engine) diagnoseIgnoreRules(projectRoot string, devMode bool) ([]string, []string, error) {
+	baseSet := e.loadBaseIgnoreList(projectRoot, devMode)
+	var entries []snapshotignoreEntry
+	if content, err := os.ReadFile(filepath.Join(projectRoot, ".snapshotignore")); err == nil {
+		entries, _ = parseSnapshotignore(string(content))
+	}
+	
+	allFiles, err := e.listFilesRecursively(projectRoot, projectRoot, newIgnoreSet())
+	if err != nil {
+		return nil, nil, err
+	}
+	
+	var reincluded, contradictions []string
+	for _, file := range allFiles {
+		var always, never *snapshotignoreEntry
+		for i := range entries {
+			entry := &entries[i]
+			if !patternMatches(entry.Pattern, file) {
+				continue
+			}
+			if entry.Section == "always" && always == nil {
+				always = entry
+			} else if entry.Section == "never" && never == nil {
+				never = entry
+			}
+		}
+		if always == nil {
+			continue
+		}
+		
+		relPath := filepath.ToSlash(file)
+		if never != nil {
+			contradictions = append(contradictions, fmt.Sprintf("%s: ALWAYS %q (line %d) and NEVER %q (line %d) both match; NEVER wins", relPath, always.Pattern, always.Line, never.Pattern, never.Line))
+			continue
+		}
+		for _, pattern := range sortedIgnorePatterns(baseSet) {
+			if source := baseSet.Sources[pattern]; patternMatches(pattern, file) {
+				reincluded = append(reincluded, fmt.Sprintf("%s: excluded by %s %q, re-included by ALWAYS %q", relPath, source, pattern, always.Pattern))
+				break
+			}
+		}
+	}
+	return reincluded, contradictions, nil
+}
+
+// Load the layers .snapshotignore builds on: git's excludes file, .gitignore and the global ignore file
+func (e *engine) loadBaseIgnoreList(projectRoot string, devMode bool) *IgnoreSet {
+	ignoreSet := newIgnoreSet()
+	
+	// Always start with .gitignore patterns as base, beneath git's own global excludes file
+	if !devMode && !e.noGitignore {
+		if excludesPath := gitExcludesFilePath(projectRoot); excludesPath != "" {
+			addIgnoreFilePatterns(ignoreSet, excludesPath, IGNORE_SOURCE_GIT_EXCLUDES)
+		}
+		addIgnoreFilePatterns(ignoreSet, filepath.Join(projectRoot, ".gitignore"), IGNORE_SOURCE_GITIGNORE)
+	}
+	
+	// User-global patterns act as a NEVER SNAPSHOT base layer below the project's rules
+	if globalPath := globalIgnorePath(); globalPath != "" {
+		addIgnoreFilePatterns(ignoreSet, globalPath, IGNORE_SOURCE_GLOBAL)
+	}
+	return ignoreSet
+}
+
+// Load ignore patterns from .gitignore and .snapshotignore with two-section parsing
+// The returned set records where each pattern came from (see IGNORE_SOURCE_*)
+func (e *engine) loadIgnoreList(projectRoot string, devMode bool) *IgnoreSet {
+	ignoreSet := e.loadBaseIgnoreList(projectRoot, devMode)
+	
+	// Read .snapshotignore file and parse the two sections
+	snapshotignorePath := filepath.Join(projectRoot, ".snapshotignore")
+	if content, err := os.ReadFile(snapshotignorePath); err == nil {
+		entries, _ := parseSnapshotignore(string(content))
+		var alwaysSnapshotPatterns []string
+		var neverSnapshotPatterns []string
+		for _, entry := range entries {
+			if entry.Section == "always" {
+				alwaysSnapshotPatterns = append(alwaysSnapshotPatterns, entry.Pattern)
+			} else if entry.Section == "never" {
+				neverSnapshotPatterns = append(neverSnapshotPatterns, entry.Pattern)
+			}
+		}
+		
+		// Apply ALWAYS SNAPSHOT rules - an allow-list checked at match time that
+		// re-includes paths excluded by .gitignore or the global ignore file
+		for _, pattern := range alwaysSnapshotPatterns {
+			ignoreSet.Sources[pattern] = IGNORE_SOURCE_ALWAYS
+		}
+		
+		// Apply NEVER SNAPSHOT rules - add to ignoreSet. A "!pattern" is an exception that
+		// re-includes paths excluded by the rules listed above it, as in .gitignore.
+		for i, pattern := range neverSnapshotPatterns {
+			// In dev mode, don't ignore tool's own files
+			if devMode {
+				toolFiles := []string{"snapshot_v2.go", ".snapshotignore", "go.mod", "go.sum"}
+				if contains(toolFiles, pattern) {
+					continue
+				}
+			}
+			ignoreSet.neverOrder[pattern] = i
+			if strings.HasPrefix(pattern, "!") {
+				ignoreSet.Sources[pattern] = IGNORE_SOURCE_EXCEPTION
+			} else {
+				ignoreSet.Sources[pattern] = IGNORE_SOURCE_NEVER
+			}
+		}
+	}
+	
+	// Always ignore the snapshot directory itself (when it lives inside the project)
+	if relPath, err := filepath.Rel(projectRoot, e.resolveSnapshotsRoot(projectRoot)); err == nil && !strings.HasPrefix(relPath, "..") {
+		ignoreSet.Sources["/"+filepath.ToSlash(relPath)] = IGNORE_SOURCE_BUILTIN
+	}
+	return ignoreSet
+}
+
+// Add each pattern of a gitignore-style file to the ignore set; a missing file adds nothing
+func addIgnoreFilePatterns(ignoreSet *IgnoreSet, path, source string) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+			ignoreSet.Sources[strings.TrimRight(normalizePatternSeparators(trimmed), "/")] = source
+		}
+	}
+}
+
+// Path configured as git's core.excludesFile, from the repository's .git/config or
+// else ~/.gitconfig. Returns "" when neither sets it.
+func gitExcludesFilePath(projectRoot string) string {
+	configPaths := []string{filepath.Join(projectRoot, ".git", "config")}
+	home, homeErr := os.UserHomeDir()
+	if homeErr == nil {
+		configPaths = append(configPaths, filepath.Join(home, ".gitconfig"))
+	}
+	
+	for _, configPath := range configPaths {
+		value := readGitConfigValue(configPath, "core", "excludesfile")
+		if value == "" {
+			continue
+		}
+		if value == "~" || strings.HasPrefix(value, "~/") {
+			if homeErr != nil {
+				return ""
+			}
+			value = filepath.Join(home, value[1:])
+		}
+		return value
+	}
+	return ""
+}
+
+// Minimal git config reader: the last value of section.key, matched case-insensitively.
+// Subsections, includes and escapes beyond surrounding quotes are not supported.
+func readGitConfigValue(configPath, section, key string) string {
+	content, err := os.ReadFile(configPath)
+	if err != nil {
+		return ""
+	}
+	value, inSection := "", false
+	for _, line := range strings.Split(string(content), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, ";") {
+			continue
+		}
+		if strings.HasPrefix(trimmed, "[") {
+			name := strings.TrimSpace(strings.Trim(trimmed, "[]"))
+			inSection = strings.EqualFold(name, section)
+			continue
+		}
+		name, val, found := strings.Cut(trimmed, "=")
+		if inSection && found && strings.EqualFold(strings.TrimSpace(name), key) {
+			value = strings.Trim(strings.TrimSpace(val), "\"")
+		}
+	}
+	return value
+}
+
+// Location of the user-global ignore file: $XDG_CONFIG_HOME/snapshot/ignore or ~/.config/snapshot/ignore
+func globalIgnorePath() string {
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		configHome = filepath.Join(home, ".config")
+	}
+	return filepath.Join(configHome, "snapshot", "ignore")
+}
+
+// Check if a path should be ignored
+func isIgnored(relPath string, ignoreSet *IgnoreSet) bool {
+	checkedOverride, overridden := false, false
+	for pattern, source := range ignoreSet.Sources {
+		if source == IGNORE_SOURCE_ALWAYS || source == IGNORE_SOURCE_EXCEPTION || !patternMatches(pattern, relPath) {
+			continue
+		}
+		if _, excepted := neverException(pattern, relPath, ignoreSet); excepted {
+			continue
+		}
+		if !overridableSource(source) {
+			return true
+		}
+		if !checkedOverride {
+			_, overridden = matchAlwaysOverride(relPath, ignoreSet)
+			checkedOverride = true
+		}
+		if !overridden {
+			return true
+		}
+	}
+	return false
+}
+
+// Check whether ALWAYS SNAPSHOT can re-include what a source excludes.
+// NEVER SNAPSHOT and the built-in snapshots directory rule always win.
+func overridableSource(source string) bool {
+	return source == IGNORE_SOURCE_GITIGNORE || source == IGNORE_SOURCE_GIT_EXCLUDES || source == IGNORE_SOURCE_GLOBAL
+}
+
+// Check whether a path matches any exclusion, before ALWAYS SNAPSHOT overrides are applied
+func matchesExclusion(relPath string, ignoreSet *IgnoreSet) bool {
+	for pattern, source := range ignoreSet.Sources {
+		if source != IGNORE_SOURCE_ALWAYS && source != IGNORE_SOURCE_EXCEPTION && patternMatches(pattern, relPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// Find the "!pattern" exception listed below a NEVER SNAPSHOT pattern that re-includes a path.
+// Like an ALWAYS glob, an anchored exception also lets the walk enter its parent directories.
+func neverException(pattern, relPath string, ignoreSet *IgnoreSet) (string, bool) {
+	if ignoreSet.Sources[pattern] != IGNORE_SOURCE_NEVER {
+		return "", false
+	}
+	order := ignoreSet.neverOrder[pattern]
+	for candidate, source := range ignoreSet.Sources {
+		if source != IGNORE_SOURCE_EXCEPTION || ignoreSet.neverOrder[candidate] < order {
+			continue
+		}
+		exception := strings.TrimPrefix(candidate, "!")
+		if patternMatches(exception, relPath) || patternMatchesBelow(exception, relPath) {
+			return candidate, true
+		}
+	}
+	return "", false
+}
+
+// Find the exception that re-includes a path some NEVER SNAPSHOT pattern matches, if any
+func matchNeverException(relPath string, ignoreSet *IgnoreSet) (string, bool) {
+	for _, pattern := range sortedIgnorePatterns(ignoreSet) {
+		if !patternMatches(pattern, relPath) {
+			continue
+		}
+		if exception, ok := neverException(pattern, relPath, ignoreSet); ok {
+			return exception, true
+		}
+	}
+	return "", false
+}
+
+// Find the pattern (and its source) that excludes a path, if any
+func matchIgnore(relPath string, ignoreSet *IgnoreSet) (string, string, bool) {
+	for _, pattern := range sortedIgnorePatterns(ignoreSet) {
+		source := ignoreSet.Sources[pattern]
+		if source == IGNORE_SOURCE_ALWAYS || source == IGNORE_SOURCE_EXCEPTION || !patternMatches(pattern, relPath) {
+			continue
+		}
+		if _, excepted := neverException(pattern, relPath, ignoreSet); excepted {
+			continue
+		}
+		if _, overridden := matchAlwaysOverride(relPath, ignoreSet); overridden && overridableSource(source) {
+			continue
+		}
+		return pattern, source, true
+	}
+	return "", "", false
+}
+
+// Find the ALWAYS SNAPSHOT override that keeps a path included, if any. A directory
+// also counts when an anchored glob such as build/*.wasm could match inside it, so
+// the walk still descends into an otherwise ignored build/.
+func matchAlwaysOverride(relPath string, ignoreSet *IgnoreSet) (string, bool) {
+	for _, pattern := range sortedIgnorePatterns(ignoreSet) {
+		if ignoreSet.Sources[pattern] != IGNORE_SOURCE_ALWAYS {
+			continue
+		}
+		if patternMatches(pattern, relPath) || patternMatchesBelow(pattern, relPath) {
+			return pattern, true
+		}
+	}
+	return "", false
+}
+
+// Check whether an anchored pattern could match a path inside dirPath
+func patternMatchesBelow(pattern, dirPath string) bool {
+	normalized := filepath.ToSlash(dirPath)
+	if caseInsensitiveFS {
+		normalized = strings.ToLower(normalized)
+		pattern = strings.ToLower(pattern)
+	}
+	pattern = strings.TrimSuffix(pattern, "/")
+	if !strings.Contains(pattern, "/") {
+		return false
+	}
+	patternParts := strings.Split(strings.TrimPrefix(pattern, "/"), "/")
+	dirParts := strings.Split(normalized, "/")
+	if len(dirParts) >= len(patternParts) {
+		return false
+	}
+	for i, part := range dirParts {
+		if matched, _ := filepath.Match(patternParts[i], part); !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// Ignore patterns in a stable order so explanations are reproducible
+func sortedIgnorePatterns(ignoreSet *IgnoreSet) []string {
+	patterns := make([]string, 0, len(ignoreSet.Sources))
+	for pattern := range ignoreSet.Sources {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	return patterns
+}
+
+// Check whether a single ignore pattern matches a path (or one of its parent directories).
+// As in .gitignore, a pattern with a leading or inner "/" is anchored to the project root;
+// otherwise it matches a file or directory name at any depth.
+func patternMatches(pattern, relPath string) bool {
+	normalized := filepath.ToSlash(relPath)
+	if caseInsensitiveFS {
+		normalized = strings.ToLower(normalized)
+		pattern = strings.ToLower(pattern)
+	}
+	pattern = strings.TrimSuffix(pattern, "/")
+	anchored := strings.Contains(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+	if pattern == "" {
+		return false
+	}
+	
+	parts := strings.Split(normalized, "/")
+	for i := range parts {
+		candidate := parts[i]
+		if anchored {
+			candidate = strings.Join(parts[:i+1], "/")
+		}
+		if matched, _ := filepath.Match(pattern, candidate); matched || candidate == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve the snapshots directory for a project root
+func (e *engine) resolveSnapshotsRoot(projectRoot string) string {
+	if filepath.IsAbs(e.snapshotsDirName) {
+		return filepath.Clean(e.snapshotsDirName)
+	}
+	return filepath.Join(projectRoot, e.snapshotsDirName)
+}
+
+// Check whether path is the snapshots directory of the tree rooted at base
+func (e *engine) isSnapshotsDir(path, base string) bool {
+	return filepath.Clean(path) == e.resolveSnapshotsRoot(base)
+}
+
+// Check for another project's snapshots directory below the root; it is always skipped
+func (e *engine) isNestedSnapshotsDir(relPath string, isDir bool) bool {
+	if !isDir || filepath.Dir(relPath) == "." {
+		return false
+	}
+	name := filepath.Base(relPath)
+	if name != SNAPSHOTS_DIR_NAME && name != filepath.Base(e.snapshotsDirName) {
+		return false
+	}
+	e.warnNestedProject(filepath.Dir(relPath), "skipping its "+name+"/")
+	return true
+}
+
+// Warn once per run about a nested project found while walking the tree
+func (e *engine) warnNestedProject(dir, action string) {
+	e.warnOnce(fmt.Sprintf("⚠️  Nested project detected in %s/: %s", filepath.ToSlash(dir), action))
+}
+
+// Print a walk warning unless it was already printed during this run. Warnings go to stderr
+// so they never mix into --name-only and other script-readable output.
+func (e *engine) warnOnce(message string) {
+	e.walkWarnedMu.Lock()
+	defer e.walkWarnedMu.Unlock()
+	if e.walkWarned[message] {
+		return
+	}
+	e.walkWarned[message] = true
+	fmt.Fprintln(e.errOut, message)
+}
+
+// Check whether a walked entry is a symlink to a directory. These are skipped unless
+// --follow-symlinks is set, and even then a link back into its own ancestors is not followed.
+func symlinkedDir(path string, mode os.FileMode) bool {
+	if mode&os.ModeSymlink == 0 {
+		return false
+	}
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// Check whether a walked entry is a symlink to a file (or a dangling link). Like directory
+// links these are skipped unless --follow-symlinks is set, which stores the target's contents.
+func symlinkedFile(path string, mode os.FileMode) bool {
+	return mode&os.ModeSymlink != 0 && !symlinkedDir(path, mode)
+}
+
+// Check whether following a directory symlink would re-enter a directory it sits in.
+// Every ancestor is resolved through its own links, so loops spanning several links are caught.
+func symlinkLoops(base, linkPath string) bool {
+	target, err := filepath.EvalSymlinks(linkPath)
+	if err != nil {
+		return true
+	}
+	relDir, err := filepath.Rel(base, filepath.Dir(linkPath))
+	if err != nil {
+		return true
+	}
+	dir := base
+	parts := []string{}
+	if relDir != "." {
+		parts = strings.Split(relDir, string(filepath.Separator))
+	}
+	for i := 0; i <= len(parts); i++ {
+		if i > 0 {
+			dir = filepath.Join(dir, parts[i-1])
+		}
+		real, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			return true
+		}
+		if real == target || strings.HasPrefix(real, target+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Display form of the snapshots directory for prompts and messages
+func (e *engine) snapshotsDisplayPath() string {
+	return filepath.ToSlash(e.snapshotsDirName)
+}
+
+// List files recursively, respecting ignore patterns
+func (e *engine) listFilesRecursively(dir, base string, ignoreSet *IgnoreSet) ([]string, error) {
+	if base == "" {
+		base = dir
+	}
+	
+	var fileList []string
+	
+	var visit filepath.WalkFunc
+	visit = func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// Skip files we can't access, but remember them for the summary
+			if walkErr := e.recordFileError(path, err); walkErr != nil {
+				return walkErr
+			}
+			if info != nil && info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		
+		relPath, err := filepath.Rel(base, path)
+		if err != nil {
+			return nil
+		}
+		
+		if relPath == "." {
+			return nil
+		}
+		
+		// Critical: Prevent recursion into the snapshots directory itself, or a nested project's
+		if e.isSnapshotsDir(path, base) || e.isNestedSnapshotsDir(relPath, info.IsDir()) {
+			return filepath.SkipDir
+		}
+		
+		if isIgnored(relPath, ignoreSet) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		
+		if info.Name() == ".snapshotignore" && filepath.Dir(relPath) != "." {
+			e.warnNestedProject(filepath.Dir(relPath), "its .snapshotignore is not applied (only the root one is)")
+		}
+		
+		// The file index and metadata are snapshot bookkeeping, not project content
+		if relPath == SNAPSHOT_INDEX_FILE || relPath == SNAPSHOT_META_FILE {
+			return nil
+		}
+		
+		if e.beyondMaxDepth(relPath, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		
+		// Walk a followed directory link's target as if it lived at the link's path
+		if symlinkedDir(path, info.Mode()) {
+			if !e.followSymlinks {
+				return nil
+			}
+			if symlinkLoops(base, path) {
+				e.warnOnce(fmt.Sprintf("⚠️  Not following %s: the link loops back into its own parent", filepath.ToSlash(relPath)))
+				return nil
+			}
+			target, _ := filepath.EvalSymlinks(path)
+			return filepath.Walk(target, func(targetPath string, info os.FileInfo, err error) error {
+				rel, _ := filepath.Rel(target, targetPath)
+				return visit(filepath.Join(path, rel), info, err)
+			})
+		}
+		if !e.followSymlinks && symlinkedFile(path, info.Mode()) {
+			return nil
+		}
+		
+		if !info.IsDir() && !e.exceedsMaxFileSize(info.Size()) {
+			fileList = append(fileList, relPath)
+		}
+		
+		return nil
+	}
+	
+	err := filepath.Walk(dir, visit)
+	return fileList, err
+}
+
+// Sum the sizes of files relative to root
+func totalFileSize(root string, files []string) uint64 {
+	var total uint64
+	for _, relPath := range files {
+		if info, err := os.Stat(filepath.Join(root, relPath)); err == nil {
+			total += uint64(info.Size())
+		}
+	}
+	return total
+}
+
+// Make sure the passphrase is known, taking it from PASSPHRASE_ENV_VAR or asking for it.
+// Only call this before starting parallel work, never from a worker.
+func (e *engine) requirePassphrase() error {
+	e.encryptionMu.Lock()
+	known := e.encryptionPassphrase != ""
+	e.encryptionMu.Unlock()
+	if known {
+		return nil
+	}
+	passphrase := os.Getenv(PASSPHRASE_ENV_VAR)
+	if passphrase == "" {
+		answer, err := e.askUser("🔑 Passphrase for encrypted snapshot: ")
+		if err != nil || answer == "" {
+			return fmt.Errorf("a passphrase is required (set %s or enter one)", PASSPHRASE_ENV_VAR)
+		}
+		passphrase = answer
+	}
+	e.encryptionMu.Lock()
+	e.encryptionPassphrase = passphrase
+	e.encryptionMu.Unlock()
+	return nil
+}
+
+// Derive the AES-256 key for a salt from the passphrase, which requirePassphrase must already
+// have set. Never prompts, so it is safe from parallel workers.
+func (e *engine) deriveKey(salt []byte) ([]byte, error) {
+	e.encryptionMu.Lock()
+	key, ok := e.derivedKeys[string(salt)]
+	passphrase := e.encryptionPassphrase
+	e.encryptionMu.Unlock()
+	if ok {
+		return key, nil
+	}
+	if passphrase == "" {
+		return nil, fmt.Errorf("a passphrase is required (set %s or enter one)", PASSPHRASE_ENV_VAR)
+	}
+	key, err := pbkdf2.Key(sha256.New, passphrase, salt, ENCRYPTION_KDF_ITERATIONS, 32)
+	if err != nil {
+		return nil, err
+	}
+	e.encryptionMu.Lock()
+	e.derivedKeys[string(salt)] = key
+	e.encryptionMu.Unlock()
+	return key, nil
+}
+
+// Get ready to read a snapshot that may be encrypted: ask for the passphrase and derive the keys
+// for it and for every snapshot its incremental references lead into, so that parallel readers
+// never prompt. Paths that aren't snapshots, like the working directory, are left alone.
+func (e *engine) unlockSnapshot(snapshotPath string) error {
+	pending := []string{filepath.Clean(snapshotPath)}
+	seen := make(map[string]bool)
+	for len(pending) > 0 {
+		path := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+		
+		meta, ok := loadSnapshotMeta(path)
+		if !ok {
+			continue
+		}
+		if meta.Salt != "" {
+			salt, err := hex.DecodeString(meta.Salt)
+			if err != nil || len(salt) != ENCRYPTION_SALT_SIZE {
+				return fmt.Errorf("%s has an invalid encryption salt", filepath.Base(path))
+			}
+			if err := e.requirePassphrase(); err != nil {
+				return err
+			}
+			if _, err := e.deriveKey(salt); err != nil {
+				return err
+			}
+			e.encryptionMu.Lock()
+			e.sealedSnapshots[path] = true
+			e.encryptionMu.Unlock()
+			if err := e.checkPassphrase(path); err != nil {
+				return err
+			}
+		}
+		
+		refs := make(map[int]bool)
+		for _, entry := range loadFileIndex(path) {
+			if entry.Ref != 0 {
+				refs[entry.Ref] = true
+			}
+		}
+		for ref := range refs {
+			if folder := findSnapshotByIndex(filepath.Dir(path), ref); folder != "" {
+				pending = append(pending, filepath.Join(filepath.Dir(path), folder))
+			}
+		}
+	}
+	return nil
+}
+
+// Confirm the passphrase opens a sealed snapshot by decrypting the first chunk of one of its files,
+// so a wrong one fails the command up front instead of as scattered per-file read errors
+func (e *engine) checkPassphrase(snapshotPath string) error {
+	var stored []string
+	for relPath, entry := range loadFileIndex(snapshotPath) {
+		if entry.Ref == 0 {
+			stored = append(stored, relPath)
+		}
+	}
+	sort.Strings(stored)
+	for _, relPath := range stored {
+		file, err := e.openSnapshotFile(filepath.Join(snapshotPath, filepath.FromSlash(relPath)))
+		if err != nil {
+			continue // Missing files are reported where they are read
+		}
+		_, err = file.Read(make([]byte, 1))
+		file.Close()
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("cannot decrypt %s: wrong passphrase or corrupted data", filepath.Base(snapshotPath))
+		}
+		return nil
+	}
+	return nil
+}
+
+// Report whether a path lies inside an unlocked encrypted snapshot
+func (e *engine) inSealedSnapshot(path string) bool {
+	e.encryptionMu.Lock()
+	defer e.encryptionMu.Unlock()
+	for dir := range e.sealedSnapshots {
+		if strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Size on disk of a file holding size bytes once sealed
+func sealedSize(size int64) int64 {
+	chunks := size/ENCRYPTION_CHUNK_SIZE + 1
+	return int64(len(ENCRYPTED_FILE_MAGIC)+ENCRYPTION_SALT_SIZE+ENCRYPTION_NONCE_SIZE) + size + chunks*ENCRYPTION_TAG_SIZE
+}
+
+// Build the AES-GCM cipher for a key
+func newSnapshotCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Nonce for one chunk: the file's base nonce with the chunk number XORed into its last 8 bytes
+func chunkNonce(base []byte, chunk uint64) []byte {
+	nonce := append([]byte{}, base...)
+	tail := nonce[len(nonce)-8:]
+	binary.BigEndian.PutUint64(tail, binary.BigEndian.Uint64(tail)^chunk)
+	return nonce
+}
+
+// Additional data marking whether a chunk is the file's last one
+func chunkAD(final bool) []byte {
+	if final {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+// Seals everything written to it in chunks; Close writes the final chunk
+type sealedWriter struct {
+	dst   io.Writer
+	gcm   cipher.AEAD
+	nonce []byte
+	chunk uint64
+	buf   []byte
+	out   []byte
+}
+
+// Start a sealed file on dst, writing its header
+func newSealedWriter(dst io.Writer, key, salt []byte) (*sealedWriter, error) {
+	gcm, err := newSnapshotCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, ENCRYPTION_NONCE_SIZE)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	header := append([]byte(ENCRYPTED_FILE_MAGIC), salt...)
+	if _, err := dst.Write(append(header, nonce...)); err != nil {
+		return nil, err
+	}
+	return &sealedWriter{dst: dst, gcm: gcm, nonce: nonce, buf: make([]byte, 0, ENCRYPTION_CHUNK_SIZE)}, nil
+}
+
+func (w *sealedWriter) Write(p []byte) (int, error) {
+	written := len(p)
+	for len(p) > 0 {
+		n := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		// A full chunk is never the last one; Close always writes a shorter final chunk
+		if len(w.buf) == cap(w.buf) {
+			if err := w.seal(false); err != nil {
+				return written - len(p), err
+			}
+		}
+	}
+	return written, nil
+}
+
+func (w *sealedWriter) Close() error {
+	return w.seal(true)
+}
+
+func (w *sealedWriter) seal(final bool) error {
+	w.out = w.gcm.Seal(w.out[:0], chunkNonce(w.nonce, w.chunk), w.buf, chunkAD(final))
+	w.chunk++
+	w.buf = w.buf[:0]
+	_, err := w.dst.Write(w.out)
+	return err
+}
+
+// Decrypts a file written by sealedWriter as it is read
+type sealedReader struct {
+	e       *engine
+	src     io.ReadCloser
+	gcm     cipher.AEAD
+	nonce   []byte
+	chunk   uint64
+	sealed  []byte
+	plain   []byte
+	pending []byte
+	done    bool
+}
+
+func (r *sealedReader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// Decrypt the next chunk; a short read means it is the final one
+func (r *sealedReader) next() error {
+	n, err := io.ReadFull(r.src, r.sealed)
+	final := false
+	switch err {
+	case nil:
+	case io.ErrUnexpectedEOF:
+		final = true
+	case io.EOF:
+		return fmt.Errorf("truncated encrypted file")
+	default:
+		return err
+	}
+	plain, err := r.gcm.Open(r.plain[:0], chunkNonce(r.nonce, r.chunk), r.sealed[:n], chunkAD(final))
+	if err != nil {
+		r.e.warnOnce("⚠️  Could not decrypt snapshot files: wrong passphrase or corrupted data")
+		return fmt.Errorf("decryption failed (wrong passphrase?)")
+	}
+	r.chunk++
+	r.plain, r.pending, r.done = plain, plain, final
+	return nil
+}
+
+func (r *sealedReader) Close() error {
+	return r.src.Close()
+}
+
+// Open a file for reading. Files inside an unlocked encrypted snapshot are decrypted as they are
+// read; anything else, including working-tree files, is opened as is.
+func (e *engine) openSnapshotFile(path string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil || !e.inSealedSnapshot(path) {
+		return file, err
+	}
+	header := make([]byte, len(ENCRYPTED_FILE_MAGIC)+ENCRYPTION_SALT_SIZE+ENCRYPTION_NONCE_SIZE)
+	n, err := io.ReadFull(file, header)
+	if string(header[:min(n, len(ENCRYPTED_FILE_MAGIC))]) != ENCRYPTED_FILE_MAGIC {
+		// An export resolves references into unencrypted snapshots, so a few files may be plaintext
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			file.Close()
+			return nil, err
+		}
+		return file, nil
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("truncated encrypted file")
+	}
+	salt := header[len(ENCRYPTED_FILE_MAGIC) : len(ENCRYPTED_FILE_MAGIC)+ENCRYPTION_SALT_SIZE]
+	key, err := e.deriveKey(salt)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	gcm, err := newSnapshotCipher(key)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &sealedReader{
+		e:      e,
+		src:    file,
+		gcm:    gcm,
+		nonce:  header[len(header)-ENCRYPTION_NONCE_SIZE:],
+		sealed: make([]byte, ENCRYPTION_CHUNK_SIZE+ENCRYPTION_TAG_SIZE),
+	}, nil
+}
+
+// Read a whole file, decrypting it when it lies inside an unlocked encrypted snapshot
+func (e *engine) readSnapshotFile(path string) ([]byte, error) {
+	file, err := e.openSnapshotFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+// Size of a file's contents, from the snapshot's file index when it has an entry
+// (encrypted files are larger on disk than what they hold)
+func indexedSize(index map[string]FileIndexEntry, relPath string, info os.FileInfo) int64 {
+	if entry, ok := index[filepath.ToSlash(relPath)]; ok {
+		return entry.Size
+	}
+	return info.Size()
+}
+
+// Hash file content
+func (e *engine) hashFile(filePath string) (string, error) {
+	file, err := e.openSnapshotFile(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	
+	hasher := sha1.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// Hash a file, using the snapshot's file index when it has an entry
+func (e *engine) hashFileIndexed(index map[string]FileIndexEntry, relPath, filePath string) (string, error) {
+	if entry, ok := index[filepath.ToSlash(relPath)]; ok {
+		return entry.Hash, nil
+	}
+	return e.hashFile(filePath)
+}
+
+// Write the file index (path -> size + SHA-1) into a snapshot directory
+func writeFileIndex(snapshotPath string, index map[string]FileIndexEntry) error {
+	indexJSON, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(snapshotPath, SNAPSHOT_INDEX_FILE), indexJSON, 0644)
+}
+
+// Load a snapshot's file index; returns nil for snapshots created without one
+func loadFileIndex(snapshotPath string) map[string]FileIndexEntry {
+	content, err := os.ReadFile(filepath.Join(snapshotPath, SNAPSHOT_INDEX_FILE))
+	if err != nil {
+		return nil
+	}
+	var index map[string]FileIndexEntry
+	if err := json.Unmarshal(content, &index); err != nil {
+		return nil
+	}
+	return index
+}
+
+// List a snapshot's files from its index when present, falling back to a full walk.
+// The returned index is nil when the walk was used.
+func (e *engine) listSnapshotFiles(snapshotPath string, ignoreSet *IgnoreSet) ([]string, map[string]FileIndexEntry, error) {
+	index := loadFileIndex(snapshotPath)
+	if index == nil {
+		files, err := e.listFilesRecursively(snapshotPath, snapshotPath, ignoreSet)
+		return files, nil, err
+	}
+	
+	// Apply the current ignore rules and size limit, just as a walk would
+	var files []string
+	for relPath, entry := range index {
+		if isIgnored(relPath, ignoreSet) || e.exceedsMaxFileSize(entry.Size) || e.beyondMaxDepth(relPath, false) {
+			continue
+		}
+		files = append(files, filepath.FromSlash(relPath))
+	}
+	sort.Strings(files)
+	return files, index, nil
+}
+
+// Return a function that locates a snapshot file's content, following incremental
+// references back through parent snapshots. Safe for concurrent use.
+func snapshotFileResolver(snapshotPath string, index map[string]FileIndexEntry) func(relPath string) string {
+	snapshotsRoot := filepath.Dir(snapshotPath)
+	folders := make(map[int]string)
+	var mu sync.Mutex
+	folderFor := func(ref int) string {
+		mu.Lock()
+		defer mu.Unlock()
+		folder, ok := folders[ref]
+		if !ok {
+			folder = findSnapshotByIndex(snapshotsRoot, ref)
+			folders[ref] = folder
+		}
+		return folder
+	}
+	
+	return func(relPath string) string {
+		key := filepath.ToSlash(relPath)
+		path := filepath.Join(snapshotPath, filepath.FromSlash(key))
+		entry, ok := index[key]
+		for seen := make(map[int]bool); ok && entry.Ref != 0 && !seen[entry.Ref]; {
+			seen[entry.Ref] = true
+			folder := folderFor(entry.Ref)
+			if folder == "" {
+				// The referenced snapshot is gone; callers see a missing file
+				return filepath.Join(snapshotPath, filepath.FromSlash(key))
+			}
+			holder := filepath.Join(snapshotsRoot, folder)
+			path = filepath.Join(holder, filepath.FromSlash(key))
+			if _, err := os.Lstat(path); err == nil {
+				return path
+			}
+			entry, ok = loadFileIndex(holder)[key]
+		}
+		return path
+	}
+}
+
+// Re-hash a snapshot against its file index; returns damage found and softer warnings
+func (e *engine) verifySnapshot(snapshotPath string) ([]string, []string, error) {
+	var problems, warnings []string
+	
+	if err := e.unlockSnapshot(snapshotPath); err != nil {
+		return nil, nil, err
+	}
+	onDisk, err := e.listFilesRecursively(snapshotPath, snapshotPath, newIgnoreSet())
+	if err != nil {
+		return nil, nil, err
+	}
+	
+	index := loadFileIndex(snapshotPath)
+	if index == nil {
+		// Older snapshots have no index, so only check that every file is readable
+		warnings = append(warnings, "no "+SNAPSHOT_INDEX_FILE+" in this snapshot; only checking that files are readable")
+		for _, relPath := range onDisk {
+			fullPath := filepath.Join(snapshotPath, relPath)
+			if _, err := e.hashFile(fullPath); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: unreadable (%v)", filepath.ToSlash(relPath), err))
+			} else if info, err := os.Stat(fullPath); err == nil && info.Size() == 0 {
+				warnings = append(warnings, fmt.Sprintf("%s: zero-byte file", filepath.ToSlash(relPath)))
+			}
+		}
+		return problems, warnings, nil
+	}
+	
+	var indexed []string
+	for relPath := range index {
+		indexed = append(indexed, relPath)
+	}
+	sort.Strings(indexed)
+	
+	resolve := snapshotFileResolver(snapshotPath, index)
+	for _, relPath := range indexed {
+		expected := index[relPath]
+		fullPath := resolve(relPath)
+		info, err := os.Stat(fullPath)
+		if err != nil {
+			if expected.Ref != 0 {
+				problems = append(problems, fmt.Sprintf("%s: missing from referenced snapshot %s", relPath, padNumber(expected.Ref, SNAPSHOT_INDEX_WIDTH)))
+			} else {
+				problems = append(problems, fmt.Sprintf("%s: missing", relPath))
+			}
+			continue
+		}
+		// Encrypted files carry a header and a tag per chunk on top of their contents
+		if info.Size() != expected.Size && !(e.inSealedSnapshot(fullPath) && info.Size() == sealedSize(expected.Size)) {
+			problems = append(problems, fmt.Sprintf("%s: size is %d bytes, expected %d", relPath, info.Size(), expected.Size))
+			continue
+		}
+		hash, err := e.hashFile(fullPath)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: unreadable (%v)", relPath, err))
+		} else if hash != expected.Hash {
+			problems = append(problems, fmt.Sprintf("%s: content hash mismatch", relPath))
+		}
+	}
+	
+	for _, relPath := range onDisk {
+		if _, ok := index[filepath.ToSlash(relPath)]; !ok {
+			warnings = append(warnings, fmt.Sprintf("%s: not in the file index", filepath.ToSlash(relPath)))
+		}
+	}
+	
+	return problems, warnings, nil
+}
+
+// Collapse all runs of whitespace so formatting-only edits compare equal
+func normalizeWhitespace(content string) string {
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		lines[i] = strings.Join(strings.Fields(line), " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Convert CRLF line endings to LF (a trailing lone CR from a split line is dropped too)
+func normalizeLineEndings(content string) string {
+	return strings.TrimSuffix(strings.ReplaceAll(content, "\r\n", "\n"), "\r")
+}
+
+// Compare two lines according to the diff options
+func linesEqual(a, b string, opts DiffOptions) bool {
+	if opts.IgnoreEOL {
+		a, b = normalizeLineEndings(a), normalizeLineEndings(b)
+	}
+	if opts.IgnoreWhitespace {
+		return strings.Join(strings.Fields(a), " ") == strings.Join(strings.Fields(b), " ")
+	}
+	return a == b
+}
+
+// Describe a change that only adds or removes the final newline, or "" for any other change
+func trailingNewlineChange(oldContent, newContent string) string {
+	oldBody, newBody := strings.TrimSuffix(oldContent, "\n"), strings.TrimSuffix(newContent, "\n")
+	if oldBody != newBody || oldContent == newContent {
+		return ""
+	}
+	if len(newContent) > len(oldContent) {
+		return "Only the newline at end of file was added"
+	}
+	return "Only the newline at end of file was removed"
+}
+
+// Simple unified diff implementation
+func createUnifiedDiff(oldContent, newContent, filename string, opts DiffOptions) string {
+	filename = filepath.ToSlash(filename)
+	
+	var result []string
+	result = append(result, fmt.Sprintf("--- a/%s", filename))
+	result = append(result, fmt.Sprintf("+++ b/%s", filename))
+	result = append(result, unifiedDiffHunks(oldContent, newContent, opts)...)
+	return strings.Join(result, "\n")
+}
+
+// Split content into lines, reporting whether the last line ends with a newline
+func splitDiffLines(content string) ([]string, bool) {
+	if content == "" {
+		return nil, true
+	}
+	return strings.Split(strings.TrimSuffix(content, "\n"), "\n"), strings.HasSuffix(content, "\n")
+}
+
+// Build the hunk lines of a unified diff (without the ---/+++ headers)
+func unifiedDiffHunks(oldContent, newContent string, opts DiffOptions) []string {
+	if opts.IgnoreEOL {
+		oldContent, newContent = normalizeLineEndings(oldContent), normalizeLineEndings(newContent)
+	}
+	oldLines, oldNewline := splitDiffLines(oldContent)
+	newLines, newNewline := splitDiffLines(newContent)
+	const noNewline = "\\ No newline at end of file"
+	
+	// Hunk start is the line before the change when a side has no lines in it
+	hunkRange := func(start, total int) string {
+		count := total - start
+		if count == 0 {
+			return fmt.Sprintf("%d,0", start)
+		}
+		return fmt.Sprintf("%d,%d", start+1, count)
+	}
+	
+	var result []string
+	
+	// Simple line-by-line comparison
+	maxLines := len(oldLines)
+	if len(newLines) > maxLines {
+		maxLines = len(newLines)
+	}
+	
+	contextStart := -1
+	for i := 0; i < maxLines; i++ {
+		inOld := i < len(oldLines)
+		inNew := i < len(newLines)
+		oldMissingNewline := inOld && i == len(oldLines)-1 && !oldNewline
+		newMissingNewline := inNew && i == len(newLines)-1 && !newNewline
+		
+		if !inOld || !inNew || !linesEqual(oldLines[i], newLines[i], opts) || oldMissingNewline != newMissingNewline {
+			if contextStart == -1 {
+				contextStart = i
+				result = append(result, fmt.Sprintf("@@ -%s +%s @@", hunkRange(i, len(oldLines)), hunkRange(i, len(newLines))))
+			}
+			
+			if inOld {
+				result = append(result, "-"+oldLines[i])
+				if oldMissingNewline {
+					result = append(result, noNewline)
+				}
+			}
+			if inNew {
+				result = append(result, "+"+newLines[i])
+				if newMissingNewline {
+					result = append(result, noNewline)
+				}
+			}
+		} else if contextStart != -1 {
+			result = append(result, " "+oldLines[i])
+			if oldMissingNewline {
+				result = append(result, noNewline)
+			}
+		}
+	}
+	
+	return result
+}
+
+// Build a patch applicable with patch -p1 or git apply from a diff result.
+// Diffs are recomputed exactly (ignoring --ignore-whitespace) so the patch always applies.
+func (e *engine) buildPatch(diffData *DiffResult, basePath, comparePath string) string {
+	var lines []string
+	addFile := func(oldName, newName, oldContent, newContent string) {
+		if strings.IndexByte(oldContent, 0) >= 0 || strings.IndexByte(newContent, 0) >= 0 {
+			lines = append(lines, fmt.Sprintf("Binary files %s and %s differ", oldName, newName))
+			return
+		}
+		hunks := unifiedDiffHunks(oldContent, newContent, DiffOptions{})
+		if len(hunks) == 0 {
+			return
+		}
+		lines = append(lines, "--- "+oldName, "+++ "+newName)
+		lines = append(lines, hunks...)
+	}
+	baseFile := snapshotFileResolver(basePath, loadFileIndex(basePath))
+	compareFile := snapshotFileResolver(comparePath, loadFileIndex(comparePath))
+	readSide := func(resolve func(string) string, relPath string) string {
+		content, _ := e.readSnapshotFile(resolve(relPath))
+		return string(content)
+	}
+	
+	for _, file := range diffData.Files {
+		switch file.Status {
+		case "modified":
+			addFile("a/"+file.File, "b/"+file.File, readSide(baseFile, file.File), readSide(compareFile, file.File))
+		case "added":
+			addFile("/dev/null", "b/"+file.File, "", readSide(compareFile, file.File))
+		case "removed":
+			addFile("a/"+file.File, "/dev/null", readSide(baseFile, file.File), "")
+		case "renamed":
+			// Plain unified diffs can't express a rename, so remove the old path and add the new one
+			addFile("a/"+file.RenamedFrom, "/dev/null", readSide(baseFile, file.RenamedFrom), "")
+			addFile("/dev/null", "b/"+file.File, "", readSide(compareFile, file.File))
+		}
+	}
+	
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// Color a unified diff for the terminal: hunks cyan, additions green, deletions red
+func (e *engine) colorizeDiff(diff string) string {
+	lines := strings.Split(diff, "\n")
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "+++") || strings.HasPrefix(line, "---"):
+			continue
+		case strings.HasPrefix(line, "@@"):
+			lines[i] = e.colorize(line, COLOR_CYAN)
+		case strings.HasPrefix(line, "+"):
+			lines[i] = e.colorize(line, COLOR_GREEN)
+		case strings.HasPrefix(line, "-"):
+			lines[i] = e.colorize(line, COLOR_RED)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Diff a single file between a snapshot and the working directory
+func (e *engine) showFileDiff(snapshotPath, currentPath, relPath string, opts DiffOptions) error {
+	if err := e.unlockSnapshot(snapshotPath); err != nil {
+		return err
+	}
+	snapContent, snapErr := e.readSnapshotFile(snapshotFileResolver(snapshotPath, loadFileIndex(snapshotPath))(relPath))
+	currContent, currErr := os.ReadFile(filepath.Join(currentPath, filepath.FromSlash(relPath)))
+	
+	switch {
+	case snapErr != nil && currErr != nil:
+		return fmt.Errorf("%s exists neither in %s nor in the working directory", relPath, filepath.Base(snapshotPath))
+	case snapErr != nil:
+		return fmt.Errorf("%s was added after %s (it is not in the snapshot)", relPath, filepath.Base(snapshotPath))
+	case currErr != nil:
+		return fmt.Errorf("%s was removed since %s (it is not in the working directory)", relPath, filepath.Base(snapshotPath))
+	}
+	
+	if linesEqual(string(snapContent), string(currContent), opts) {
+		fmt.Fprintf(e.out, "✅ %s is unchanged since %s\n", relPath, filepath.Base(snapshotPath))
+		return nil
+	}
+	fmt.Fprintln(e.out, e.colorizeDiff(createUnifiedDiff(string(snapContent), string(currContent), relPath, opts)))
+	return nil
+}
+
+// Trace one file through every snapshot (and the working directory), one row per snapshot
+func (e *engine) fileHistory(snapshotsRoot, projectRoot, relPath string) ([]string, error) {
+	var lines []string
+	var prevHash string
+	prevLines, present, seen := 0, false, false
+	
+	addRow := func(label, hash string, exists bool, filePath string) {
+		status := "absent"
+		lineCount := 0
+		if exists {
+			lineCount = e.countFileLines(filePath)
+		}
+		switch {
+		case exists && !present && !seen:
+			status = fmt.Sprintf("added      %d line(s)", lineCount)
+		case exists && !present:
+			status = fmt.Sprintf("re-added   %d line(s)", lineCount)
+		case exists && hash == prevHash:
+			status = "unchanged"
+		case exists:
+			status = fmt.Sprintf("changed    %+d line(s), now %d", lineCount-prevLines, lineCount)
+		case present:
+			status = "removed"
+		}
+		lines = append(lines, fmt.Sprintf("  %-6s %s", label, status))
+		prevHash, prevLines, present = hash, lineCount, exists
+		seen = seen || exists
+	}
+	
+	for _, index := range listSnapshotIndices(snapshotsRoot) {
+		snapshotPath := filepath.Join(snapshotsRoot, findSnapshotByIndex(snapshotsRoot, index))
+		if err := e.unlockSnapshot(snapshotPath); err != nil {
+			return nil, err
+		}
+		fileIndex := loadFileIndex(snapshotPath)
+		filePath := snapshotFileResolver(snapshotPath, fileIndex)(relPath)
+		hash, err := e.hashFileIndexed(fileIndex, relPath, filePath)
+		addRow(padNumber(index, SNAPSHOT_INDEX_WIDTH), hash, err == nil, filePath)
+	}
+	
+	currentPath := filepath.Join(projectRoot, filepath.FromSlash(relPath))
+	hash, err := e.hashFile(currentPath)
+	addRow("now", hash, err == nil, currentPath)
+	return lines, nil
+}
+
+// Count inserted and deleted lines in a unified diff
+func countDiffChanges(diff string) (int, int) {
+	var insertions, deletions int
+	for _, line := range strings.Split(diff, "\n") {
+		if strings.HasPrefix(line, "+++") || strings.HasPrefix(line, "---") {
+			continue
+		}
+		if strings.HasPrefix(line, "+") {
+			insertions++
+		} else if strings.HasPrefix(line, "-") {
+			deletions++
+		}
+	}
+	return insertions, deletions
+}
+
+// Count the lines in a file (a final line without a newline still counts)
+func (e *engine) countFileLines(filePath string) int {
+	file, err := e.openSnapshotFile(filePath)
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+	
+	// Read in chunks so huge files don't have to fit in memory
+	count := 0
+	var last byte = '\n'
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := file.Read(buf)
+		if n > 0 {
+			count += bytes.Count(buf[:n], []byte{'\n'})
+			last = buf[n-1]
+		}
+		if err != nil {
+			break
+		}
+	}
+	if last != '\n' {
+		count++
+	}
+	return count
+}
+
+// Size above which a modified file gets no line diff
+func diffSizeLimit(opts DiffOptions) int64 {
+	if opts.MaxDiffSize > 0 {
+		return opts.MaxDiffSize
+	}
+	return DEFAULT_MAX_DIFF_SIZE
+}
+
+// Check whether path is the project directory itself (rather than a snapshot or another tree)
+func (e *engine) isProjectRoot(path string) bool {
+	if e.projectRoot != "" {
+		absPath, err := filepath.Abs(path)
+		return err == nil && absPath == e.projectRoot
+	}
+	return filepath.Base(path) == filepath.Base(os.Getenv("PWD")) || filepath.Base(path) == "."
+}
+
+// Compare snapshots with detailed diff output
+func (e *engine) compareSnapshots(snapshotPath, currentPath string, ignoreSet *IgnoreSet, opts DiffOptions) (*DiffResult, error) {
+	result := &DiffResult{
+		Base:    filepath.Base(snapshotPath),
+		Compare: "current",
+		Files:   []DiffFile{},
+	}
+	
+	// Either side may be an encrypted snapshot; get its key before the parallel hashing starts
+	for _, path := range []string{snapshotPath, currentPath} {
+		if err := e.unlockSnapshot(path); err != nil {
+			return nil, err
+		}
+	}
+	
+	if !e.isProjectRoot(currentPath) {
+		result.Compare = filepath.Base(currentPath)
+	}
+	
+	snapshotFiles, snapIndex, err := e.listSnapshotFiles(snapshotPath, ignoreSet)
+	if err != nil {
+		return result, err
+	}
+	
+	currentFiles, currIndex, err := e.listSnapshotFiles(currentPath, ignoreSet)
+	if err != nil {
+		return result, err
+	}
+	
+	// A --path snapshot only speaks for its subtree; files elsewhere are neither added nor removed
+	scope := snapshotScope(snapshotPath)
+	if scope == "" {
+		scope = snapshotScope(currentPath)
+	}
+	if scope != "" {
+		snapshotFiles = filterToScope(snapshotFiles, scope)
+		currentFiles = filterToScope(currentFiles, scope)
+	}
+	
+	if opts.CompareManifest {
+		if snapIndex != nil && currIndex != nil {
+			result.Files = compareFileIndexes(snapshotFiles, currentFiles, snapIndex, currIndex)
+			result.Summary = summarizeDiff(result.Files)
+			if opts.Stream != nil {
+				for _, file := range result.Files {
+					if err := opts.Stream(file); err != nil {
+						return result, err
+					}
+				}
+			}
+			return result, nil
+		}
+		e.warnOnce("⚠️  --compare-manifest needs a file index in both snapshots; falling back to a full compare")
+	}
+	
+	// Create sets for faster lookup
+	snapshotFileSet := make(map[string]struct{})
+	for _, f := range snapshotFiles {
+		snapshotFileSet[f] = struct{}{}
+	}
+	
+	currentFileSet := make(map[string]struct{})
+	for _, f := range currentFiles {
+		currentFileSet[f] = struct{}{}
+	}
+	
+	// Get all unique files
+	allFilesMap := make(map[string]struct{})
+	for _, f := range snapshotFiles {
+		allFilesMap[f] = struct{}{}
+	}
+	for _, f := range currentFiles {
+		allFilesMap[f] = struct{}{}
+	}
+	
+	var allFiles []string
+	for f := range allFilesMap {
+		allFiles = append(allFiles, f)
+	}
+	sort.Strings(allFiles)
+	
+	// Renames pair removed files with added ones, so with both present neither is final until detectRenames
+	anyRemoved, anyAdded := false, false
+	for _, f := range allFiles {
+		_, inSnap := snapshotFileSet[f]
+		_, inCurr := currentFileSet[f]
+		anyRemoved = anyRemoved || !inCurr
+		anyAdded = anyAdded || !inSnap
+	}
+	renamesPossible := anyRemoved && anyAdded
+	
+	// Incremental snapshots keep unchanged files in earlier snapshots
+	resolveSnap := snapshotFileResolver(snapshotPath, snapIndex)
+	resolveCurr := snapshotFileResolver(currentPath, currIndex)
+	
+	// Compare a single path; nil means the file is unchanged
+	diffEntry := func(relPath string) *DiffFile {
+		_, inSnap := snapshotFileSet[relPath]
+		_, inCurr := currentFileSet[relPath]
+		snapFile := resolveSnap(relPath)
+		currFile := resolveCurr(relPath)
+		
+		if inSnap && !inCurr {
+			deletions := e.countFileLines(snapFile)
+			return &DiffFile{
+				File:      filepath.ToSlash(relPath),
+				Status:    "removed",
+				Deletions: &deletions,
+			}
+		} else if !inSnap && inCurr {
+			insertions := e.countFileLines(currFile)
+			return &DiffFile{
+				File:       filepath.ToSlash(relPath),
+				Status:     "added",
+				Insertions: &insertions,
+			}
+		}
+		
+		errorEntry := &DiffFile{
+			File:    filepath.ToSlash(relPath),
+			Status:  "error_comparing",
+			Message: "Could not read file for comparison",
+		}
+		
+		snapInfo, err1 := os.Stat(snapFile)
+		currInfo, err2 := os.Stat(currFile)
+		if err1 != nil || err2 != nil {
+			return errorEntry
+		}
+		
+		// Differing sizes already prove the bytes differ, so only hash same-size files
+		snapSize := indexedSize(snapIndex, relPath, snapInfo)
+		currSize := indexedSize(currIndex, relPath, currInfo)
+		if snapSize == currSize {
+			if opts.TrustMtime && snapInfo.ModTime().Equal(currInfo.ModTime()) {
+				return nil
+			}
+			
+			snapHash, err1 := e.hashFileIndexed(snapIndex, relPath, snapFile)
+			currHash, err2 := e.hashFileIndexed(currIndex, relPath, currFile)
+			if err1 != nil || err2 != nil {
+				return errorEntry
+			}
+			
+			if snapHash == currHash {
+				return nil
+			}
+		}
+		
+		// Very large files would have to be held in memory twice over; report only the size change
+		if limit := diffSizeLimit(opts); snapSize > limit || currSize > limit {
+			sizeDelta := currSize - snapSize
+			return &DiffFile{
+				File:      filepath.ToSlash(relPath),
+				Status:    "modified",
+				Message:   fmt.Sprintf("Too large to diff: %s before, %s after", formatBytes(snapSize), formatBytes(currSize)),
+				SizeDelta: &sizeDelta,
+			}
+		}
+		
+		// Generate line-by-line diff for modified files
+		snapContent, _ := e.readSnapshotFile(snapFile)
+		currContent, _ := e.readSnapshotFile(currFile)
+		
+		// Differing bytes may still be equal once whitespace is normalized
+		if opts.IgnoreWhitespace && normalizeWhitespace(string(snapContent)) == normalizeWhitespace(string(currContent)) {
+			return nil
+		}
+		if opts.IgnoreEOL && normalizeLineEndings(string(snapContent)) == normalizeLineEndings(string(currContent)) {
+			return nil
+		}
+		
+		diffResult := createUnifiedDiff(string(snapContent), string(currContent), relPath, opts)
+		
+		snapLines := strings.Count(string(snapContent), "\n")
+		currLines := strings.Count(string(currContent), "\n")
+		delta := currLines - snapLines
+		if delta < 0 {
+			delta = -delta
+		}
+		insertions, deletions := countDiffChanges(diffResult)
+		
+		return &DiffFile{
+			File:         filepath.ToSlash(relPath),
+			Status:       "modified",
+			LinesChanged: &delta,
+			Insertions:   &insertions,
+			Deletions:    &deletions,
+			Diff:         diffResult,
+			Message:      trailingNewlineChange(string(snapContent), string(currContent)),
+		}
+	}
+	
+	waitsForRenames := func(status string) bool {
+		return renamesPossible && (status == "added" || status == "removed")
+	}
+	
+	// Hash and diff files across a worker pool; results land in sorted slots. When streaming, each
+	// finished slot is passed on once every slot before it has finished too, keeping path order.
+	progress := e.newProgress("Comparing", len(allFiles))
+	entries := make([]*DiffFile, len(allFiles))
+	finished := make([]bool, len(allFiles))
+	nextToStream := 0
+	var streamMu sync.Mutex
+	err = e.forEachParallel(len(allFiles), func(i int) error {
+		entry := diffEntry(allFiles[i])
+		progress.Increment()
+		if opts.Stream == nil {
+			entries[i] = entry
+			return nil
+		}
+		
+		streamMu.Lock()
+		defer streamMu.Unlock()
+		entries[i], finished[i] = entry, true
+		for ; nextToStream < len(allFiles) && finished[nextToStream]; nextToStream++ {
+			if pending := entries[nextToStream]; pending != nil && !waitsForRenames(pending.Status) {
+				if err := opts.Stream(*pending); err != nil {
+					return err
+				}
+				pending.Diff = ""
+			}
+		}
+		return nil
+	})
+	progress.Clear()
+	if err != nil {
+		return result, err
+	}
+	
+	for _, entry := range entries {
+		if entry != nil {
+			result.Files = append(result.Files, *entry)
+		}
+	}
+	
+	e.detectRenames(result, resolveSnap, resolveCurr, opts)
+	if opts.Stream != nil && renamesPossible {
+		for _, file := range result.Files {
+			if file.Status == "added" || file.Status == "removed" || file.Status == "renamed" {
+				if err := opts.Stream(file); err != nil {
+					return result, err
+				}
+			}
+		}
+	}
+	result.Summary = summarizeDiff(result.Files)
+	return result, nil
+}
+
+// Count a diff's entries by status and total their line changes
+func summarizeDiff(files []DiffFile) *DiffSummary {
+	summary := &DiffSummary{}
+	for _, file := range files {
+		switch file.Status {
+		case "added":
+			summary.Added++
+		case "modified":
+			summary.Modified++
+		case "removed":
+			summary.Removed++
+		case "renamed":
+			summary.Renamed++
+		}
+		if file.Insertions != nil {
+			summary.Insertions += *file.Insertions
+		}
+		if file.Deletions != nil {
+			summary.Deletions += *file.Deletions
+		}
+	}
+	summary.Churn = summary.Insertions + summary.Deletions
+	return summary
+}
+
+// The subtree a snapshot was limited to with --path ("" for the whole project)
+func snapshotScope(snapshotPath string) string {
+	meta, _ := loadSnapshotMeta(snapshotPath)
+	return meta.Scope
+}
+
+// Check whether a relative path lies inside a --path scope ("" is the whole project)
+func withinScope(relPath, scope string) bool {
+	relPath = filepath.ToSlash(relPath)
+	return scope == "" || relPath == scope || strings.HasPrefix(relPath, scope+"/")
+}
+
+// Keep only the paths inside a --path scope
+func filterToScope(files []string, scope string) []string {
+	var scoped []string
+	for _, file := range files {
+		if withinScope(file, scope) {
+			scoped = append(scoped, file)
+		}
+	}
+	return scoped
+}
+
+// Compare two snapshots purely through their file indexes: presence decides
+// added/removed and the stored hash decides modified. No line counts are produced.
+func compareFileIndexes(baseFiles, compareFiles []string, baseIndex, compareIndex map[string]FileIndexEntry) []DiffFile {
+	inCompare := make(map[string]struct{}, len(compareFiles))
+	for _, f := range compareFiles {
+		inCompare[f] = struct{}{}
+	}
+	
+	files := []DiffFile{}
+	inBase := make(map[string]struct{}, len(baseFiles))
+	for _, f := range baseFiles {
+		inBase[f] = struct{}{}
+		relPath := filepath.ToSlash(f)
+		if _, ok := inCompare[f]; !ok {
+			files = append(files, DiffFile{File: relPath, Status: "removed"})
+		} else if baseIndex[relPath].Hash != compareIndex[relPath].Hash {
+			sizeDelta := compareIndex[relPath].Size - baseIndex[relPath].Size
+			files = append(files, DiffFile{File: relPath, Status: "modified", SizeDelta: &sizeDelta})
+		}
+	}
+	for _, f := range compareFiles {
+		if _, ok := inBase[f]; !ok {
+			files = append(files, DiffFile{File: filepath.ToSlash(f), Status: "added"})
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].File < files[j].File })
+	return files
+}
+
+// Collapse removed+added pairs with identical or near-identical content into renames
+func (e *engine) detectRenames(result *DiffResult, snapFile, currFile func(relPath string) string, opts DiffOptions) {
+	var removed, added []int
+	for i, file := range result.Files {
+		switch file.Status {
+		case "removed":
+			removed = append(removed, i)
+		case "added":
+			added = append(added, i)
+		}
+	}
+	if len(removed) == 0 || len(added) == 0 {
+		return
+	}
+	
+	// Index added files by hash for exact matches. Empty files all share one hash, so like git
+	// they are never paired: unrelated empty files deleted and created together aren't a rename.
+	emptyHash := hex.EncodeToString(sha1.New().Sum(nil))
+	addedByHash := make(map[string][]int)
+	for _, i := range added {
+		if hash, err := e.hashFile(currFile(result.Files[i].File)); err == nil && hash != emptyHash {
+			addedByHash[hash] = append(addedByHash[hash], i)
+		}
+	}
+	
+	matched := make(map[int]bool)
+	renames := make(map[int]DiffFile) // keyed by the added entry being replaced
+	var unmatchedRemoved []int
+	for _, i := range removed {
+		hash, err := e.hashFile(snapFile(result.Files[i].File))
+		if err == nil && hash != emptyHash {
+			if candidates := addedByHash[hash]; len(candidates) > 0 {
+				target := candidates[0]
+				addedByHash[hash] = candidates[1:]
+				matched[i], matched[target] = true, true
+				zero := 0
+				renames[target] = DiffFile{
+					File:         result.Files[target].File,
+					Status:       "renamed",
+					RenamedFrom:  result.Files[i].File,
+					LinesChanged: &zero,
+				}
+				continue
+			}
+		}
+		unmatchedRemoved = append(unmatchedRemoved, i)
+	}
+	
+	// Fall back to line similarity for moves with small edits. Reading every added file for every
+	// removed one is quadratic, so only files of similar size are compared, and at most
+	// RENAME_MAX_CANDIDATES of them per removed file.
+	sizes := make(map[int]int64)
+	for _, i := range unmatchedRemoved {
+		if info, err := os.Stat(snapFile(result.Files[i].File)); err == nil && info.Size() <= diffSizeLimit(opts) {
+			sizes[i] = info.Size()
+		}
+	}
+	var unmatchedAdded []int
+	for _, j := range added {
+		if matched[j] {
+			continue
+		}
+		if info, err := os.Stat(currFile(result.Files[j].File)); err == nil && info.Size() <= diffSizeLimit(opts) {
+			sizes[j] = info.Size()
+			unmatchedAdded = append(unmatchedAdded, j)
+		}
+	}
+	for _, i := range unmatchedRemoved {
+		oldSize, ok := sizes[i]
+		if !ok {
+			continue
+		}
+		var candidates []int
+		for _, j := range unmatchedAdded {
+			if !matched[j] && similarSize(oldSize, sizes[j]) {
+				candidates = append(candidates, j)
+			}
+		}
+		if len(candidates) == 0 {
+			continue
+		}
+		sort.SliceStable(candidates, func(a, b int) bool {
+			return sizeDistance(oldSize, sizes[candidates[a]]) < sizeDistance(oldSize, sizes[candidates[b]])
+		})
+		if len(candidates) > RENAME_MAX_CANDIDATES {
+			candidates = candidates[:RENAME_MAX_CANDIDATES]
+		}
+		
+		oldContent, err := e.readSnapshotFile(snapFile(result.Files[i].File))
+		if err != nil || len(oldContent) == 0 {
+			continue
+		}
+		bestIndex, bestScore := -1, 0.0
+		var bestContent []byte
+		for _, j := range candidates {
+			newContent, err := e.readSnapshotFile(currFile(result.Files[j].File))
+			if err != nil || len(newContent) == 0 {
+				continue
+			}
+			if score := lineSimilarity(string(oldContent), string(newContent)); score > bestScore {
+				bestIndex, bestScore, bestContent = j, score, newContent
+			}
+		}
+		if bestIndex == -1 || bestScore < RENAME_SIMILARITY_THRESHOLD {
+			continue
+		}
+		
+		matched[i], matched[bestIndex] = true, true
+		newPath := result.Files[bestIndex].File
+		diffResult := createUnifiedDiff(string(oldContent), string(bestContent), newPath, opts)
+		delta := strings.Count(string(bestContent), "\n") - strings.Count(string(oldContent), "\n")
+		if delta < 0 {
+			delta = -delta
+		}
+		insertions, deletions := countDiffChanges(diffResult)
+		renames[bestIndex] = DiffFile{
+			File:         newPath,
+			Status:       "renamed",
+			RenamedFrom:  result.Files[i].File,
+			LinesChanged: &delta,
+			Insertions:   &insertions,
+			Deletions:    &deletions,
+			Diff:         diffResult,
+		}
+	}
+	
+	if len(renames) == 0 {
+		return
+	}
+	
+	files := []DiffFile{}
+	for i, file := range result.Files {
+		if rename, ok := renames[i]; ok {
+			files = append(files, rename)
+		} else if !matched[i] {
+			files = append(files, file)
+		}
+	}
+	result.Files = files
+}
+
+// Fraction of lines shared between two texts (multiset overlap over the longer side)
+func lineSimilarity(a, b string) float64 {
+	aLines := strings.Split(a, "\n")
+	bLines := strings.Split(b, "\n")
+	
+	counts := make(map[string]int)
+	for _, line := range aLines {
+		counts[line]++
+	}
+	common := 0
+	for _, line := range bLines {
+		if counts[line] > 0 {
+			counts[line]--
+			common++
+		}
+	}
+	
+	longest := len(aLines)
+	if len(bLines) > longest {
+		longest = len(bLines)
+	}
+	return float64(common) / float64(longest)
+}
+
+// Whether two file sizes are close enough for the files to pass the rename similarity threshold
+// (a cheap stand-in for line counts, so most pairs are ruled out without reading them)
+func similarSize(a, b int64) bool {
+	if a > b {
+		a, b = b, a
+	}
+	return b == 0 || float64(a)/float64(b) >= RENAME_SIMILARITY_THRESHOLD
+}
+
+// Absolute difference between two file sizes
+func sizeDistance(a, b int64) int64 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+// Accumulate changes across every consecutive snapshot pair in a range
+func (e *engine) compareSnapshotRange(snapshotsRoot string, startIndex, endIndex int, ignoreSet *IgnoreSet, opts DiffOptions) (*DiffResult, error) {
+	var folders []string
+	for _, index := range listSnapshotIndices(snapshotsRoot) {
+		if index >= startIndex && index <= endIndex {
+			folders = append(folders, findSnapshotByIndex(snapshotsRoot, index))
+		}
+	}
+	if len(folders) < 2 {
+		return nil, fmt.Errorf("need at least two snapshots between %d and %d", startIndex, endIndex)
+	}
+	
+	result := &DiffResult{
+		Base:    folders[0],
+		Compare: folders[len(folders)-1],
+		Files:   []DiffFile{},
+	}
+	
+	touched := make(map[string]*DiffFile)
+	for i := 1; i < len(folders); i++ {
+		pairDiff, err := e.compareSnapshots(filepath.Join(snapshotsRoot, folders[i-1]), filepath.Join(snapshotsRoot, folders[i]), ignoreSet, opts)
+		if err != nil {
+			return result, err
+		}
+		
+		for _, file := range pairDiff.Files {
+			entry, exists := touched[file.File]
+			if !exists {
+				entry = &DiffFile{File: file.File}
+				touched[file.File] = entry
+			}
+			// The most recent status wins; line counts accumulate across the range
+			entry.Status = file.Status
+			entry.TouchCount++
+			entry.LinesChanged = addCounts(entry.LinesChanged, file.LinesChanged)
+			entry.Insertions = addCounts(entry.Insertions, file.Insertions)
+			entry.Deletions = addCounts(entry.Deletions, file.Deletions)
+		}
+	}
+	
+	var paths []string
+	for path := range touched {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		result.Files = append(result.Files, *touched[path])
+	}
+	
+	return result, nil
+}
+
+// Sum two optional counts, staying nil only when both are nil
+func addCounts(a, b *int) *int {
+	if a == nil && b == nil {
+		return nil
+	}
+	total := 0
+	if a != nil {
+		total += *a
+	}
+	if b != nil {
+		total += *b
+	}
+	return &total
+}
+
+// Append change manifest to snapshot.log (and snapshot-log.md with --markdown-log),
+// recording per-file line counts in manifest.json
+func (e *engine) appendChangeManifest(snapshotsRoot, currentSnapshotPath string, currentIndex int, label string, createdAt time.Time, ignoreSet *IgnoreSet) error {
+	initial, sections, files, err := e.collectManifestSections(snapshotsRoot, currentSnapshotPath, currentIndex, ignoreSet)
+	if err != nil {
+		return err
+	}
+	
+	if err := appendToFile(filepath.Join(snapshotsRoot, "snapshot.log"), e.formatManifestEntry(currentIndex, label, createdAt, initial, sections)); err != nil {
+		return err
+	}
+	if err := saveManifestRecord(snapshotsRoot, newManifestRecord(currentIndex, label, createdAt, files)); err != nil {
+		return err
+	}
+	if e.markdownLog {
+		return appendToFile(filepath.Join(snapshotsRoot, MARKDOWN_LOG_FILE), e.formatMarkdownManifestEntry(currentIndex, label, createdAt, initial, sections))
+	}
+	return nil
+}
+
+// Append text to a file, creating it if needed
+func appendToFile(path, content string) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	
+	_, err = f.WriteString(content)
+	return err
+}
+
+// One titled file list in a change manifest entry (Changed, Added, Removed, Renamed)
+type manifestSection struct {
+	Name  string
+	Files []string
+}
+
+// Work out what a snapshot changed relative to the one before it, as log sections and as
+// diff entries with line counts. The first snapshot (initial) has a single Added section
+// listing everything it holds.
+func (e *engine) collectManifestSections(snapshotsRoot, currentSnapshotPath string, currentIndex int, ignoreSet *IgnoreSet) (bool, []manifestSection, []DiffFile, error) {
+	// Check if this is the first snapshot
+	previousIndex := currentIndex - 1
+	var previousFolder string
+	
+	if previousIndex > 0 {
+		previousFolder = findSnapshotByIndex(snapshotsRoot, previousIndex)
+	}
+	
+	if previousFolder == "" {
+		// First snapshot - list all files as "Added"
+		allFiles, err := e.listFilesRecursively(currentSnapshotPath, currentSnapshotPath, ignoreSet)
+		if err != nil {
+			return true, nil, nil, err
+		}
+		if len(allFiles) == 0 {
+			return true, nil, nil, nil
+		}
+		if err := e.unlockSnapshot(currentSnapshotPath); err != nil {
+			return true, nil, nil, err
+		}
+		resolve := snapshotFileResolver(currentSnapshotPath, loadFileIndex(currentSnapshotPath))
+		var files []DiffFile
+		for _, file := range allFiles {
+			insertions := e.countFileLines(resolve(file))
+			files = append(files, DiffFile{File: filepath.ToSlash(file), Status: "added", Insertions: &insertions})
+		}
+		return true, []manifestSection{{Name: "Added", Files: allFiles}}, files, nil
+	}
+	
+	// Compare with previous snapshot
+	previousPath := filepath.Join(snapshotsRoot, previousFolder)
+	
+	diffData, err := e.compareSnapshots(previousPath, currentSnapshotPath, ignoreSet, DiffOptions{})
+	if err != nil {
+		return false, nil, nil, err
+	}
+	
+	var modifiedFiles, addedFiles, removedFiles, renamedFiles []string
+	for _, f := range diffData.Files {
+		switch f.Status {
+		case "modified":
+			modifiedFiles = append(modifiedFiles, f.File)
+		case "added":
+			addedFiles = append(addedFiles, f.File)
+		case "removed":
+			removedFiles = append(removedFiles, f.File)
+		case "renamed":
+			renamedFiles = append(renamedFiles, f.RenamedFrom+" -> "+f.File)
+		}
+	}
+	
+	var sections []manifestSection
+	for _, section := range []manifestSection{{"Changed", modifiedFiles}, {"Added", addedFiles}, {"Removed", removedFiles}, {"Renamed", renamedFiles}} {
+		if len(section.Files) > 0 {
+			sections = append(sections, section)
+		}
+	}
+	return false, sections, diffData.Files, nil
+}
+
+// ManifestRecord is one snapshot's entry in manifest.json: what changed, with line counts
+type ManifestRecord struct {
+	Index           int                 `json:"index"`
+	Label           string              `json:"label"`
+	CreatedAt       time.Time           `json:"created_at"`
+	Files           []ManifestFileStats `json:"files"`
+	TotalInsertions int                 `json:"total_insertions"`
+	TotalDeletions  int                 `json:"total_deletions"`
+}
+
+// ManifestFileStats is one changed file in a ManifestRecord
+type ManifestFileStats struct {
+	File         string `json:"file"`
+	Status       string `json:"status"`
+	RenamedFrom  string `json:"renamed_from,omitempty"`
+	Insertions   int    `json:"insertions"`
+	Deletions    int    `json:"deletions"`
+	LinesChanged int    `json:"lines_changed"`
+}
+
+// Summarize diff entries as a manifest.json record
+func newManifestRecord(index int, label string, createdAt time.Time, files []DiffFile) ManifestRecord {
+	record := ManifestRecord{Index: index, Label: label, CreatedAt: createdAt, Files: []ManifestFileStats{}}
+	for _, file := range files {
+		stats := ManifestFileStats{File: file.File, Status: file.Status, RenamedFrom: file.RenamedFrom}
+		if file.Insertions != nil {
+			stats.Insertions = *file.Insertions
+		}
+		if file.Deletions != nil {
+			stats.Deletions = *file.Deletions
+		}
+		if file.LinesChanged != nil {
+			stats.LinesChanged = *file.LinesChanged
+		}
+		record.TotalInsertions += stats.Insertions
+		record.TotalDeletions += stats.Deletions
+		record.Files = append(record.Files, stats)
+	}
+	return record
+}
+
+// Read manifest.json (missing or unreadable means no records yet)
+func loadManifestRecords(snapshotsRoot string) []ManifestRecord {
+	var records []ManifestRecord
+	if content, err := os.ReadFile(filepath.Join(snapshotsRoot, MANIFEST_JSON_FILE)); err == nil {
+		json.Unmarshal(content, &records)
+	}
+	return records
+}
+
+// Add or replace a snapshot's record in manifest.json, keeping records in index order
+func saveManifestRecord(snapshotsRoot string, record ManifestRecord) error {
+	var records []ManifestRecord
+	for _, existing := range loadManifestRecords(snapshotsRoot) {
+		if existing.Index != record.Index {
+			records = append(records, existing)
+		}
+	}
+	records = append(records, record)
+	sort.Slice(records, func(i, j int) bool { return records[i].Index < records[j].Index })
+	return writeManifestRecords(snapshotsRoot, records)
+}
+
+// Write manifest.json
+func writeManifestRecords(snapshotsRoot string, records []ManifestRecord) error {
+	content, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(snapshotsRoot, MANIFEST_JSON_FILE), content, 0644)
+}
+
+// Split a section's files into those listed and the count summarized as "...and N more"
+func (e *engine) truncateManifestFiles(files []string) ([]string, int) {
+	if e.manifestLimit == 0 || len(files) <= e.manifestLimit {
+		return files, 0
+	}
+	return files[:e.manifestLimit], len(files) - e.manifestLimit
+}
+
+// Render a snapshot.log entry
+func (e *engine) formatManifestEntry(currentIndex int, label string, createdAt time.Time, initial bool, sections []manifestSection) string {
+	timestamp := createdAt.Format("2006-01-02 15:04:05")
+	paddedIndex := padNumber(currentIndex, SNAPSHOT_INDEX_WIDTH)
+	
+	// A multi-line description keeps its first line in the header and the rest indented beneath
+	title, details, _ := strings.Cut(label, "\n")
+	var lines []string
+	lines = append(lines, fmt.Sprintf("[%s] %s - \"%s\"", paddedIndex, timestamp, title))
+	if details != "" {
+		for _, line := range strings.Split(details, "\n") {
+			lines = append(lines, strings.TrimRight("  "+line, " "))
+		}
+	}
+	lines = append(lines, "")
+	
+	if initial && len(sections) > 0 {
+		lines = append(lines, "Initial snapshot")
+		lines = append(lines, "")
+		lines = append(lines, "Added:")
+		
+		shown, more := e.truncateManifestFiles(sections[0].Files)
+		for _, file := range shown {
+			lines = append(lines, "  - "+file)
+		}
+		if more > 0 {
+			lines = append(lines, fmt.Sprintf("  ...and %d more files", more))
+		}
+	} else {
+		for _, section := range sections {
+			lines = append(lines, section.Name+":")
+			shown, more := e.truncateManifestFiles(section.Files)
+			for _, file := range shown {
+				lines = append(lines, "  - "+file)
+			}
+			if more > 0 {
+				lines = append(lines, fmt.Sprintf("  ...and %d more %s files", more, strings.ToLower(section.Name)))
+			}
+			lines = append(lines, "")
+		}
+	}
+	
+	lines = append(lines, "----------------------------------------")
+	lines = append(lines, "")
+	
+	return strings.Join(lines, "\n")
+}
+
+// Render the same entry as Markdown for snapshot-log.md
+func (e *engine) formatMarkdownManifestEntry(currentIndex int, label string, createdAt time.Time, initial bool, sections []manifestSection) string {
+	title, details, _ := strings.Cut(label, "\n")
+	var lines []string
+	lines = append(lines, fmt.Sprintf("## %s — %s", padNumber(currentIndex, SNAPSHOT_INDEX_WIDTH), title))
+	lines = append(lines, "")
+	if details != "" {
+		lines = append(lines, details)
+		lines = append(lines, "")
+	}
+	lines = append(lines, fmt.Sprintf("*%s*", createdAt.Format("2006-01-02 15:04:05")))
+	lines = append(lines, "")
+	if initial && len(sections) > 0 {
+		lines = append(lines, "Initial snapshot")
+		lines = append(lines, "")
+	}
+	
+	for _, section := range sections {
+		lines = append(lines, fmt.Sprintf("**%s**", section.Name))
+		lines = append(lines, "")
+		shown, more := e.truncateManifestFiles(section.Files)
+		for _, file := range shown {
+			lines = append(lines, fmt.Sprintf("- `%s`", file))
+		}
+		if more > 0 {
+			lines = append(lines, fmt.Sprintf("- ...and %d more", more))
+		}
+		lines = append(lines, "")
+	}
+	
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// Names of the reports --diff, --prompt and --analyze-regression write; submatches are the snapshot indices they refer to
+var artifactPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`^diff_(\d{4,})_to_(?:(\d{4,})|current|external)\.(?:json|jsonl|html|patch)(?:\.gz)?$`),
+	regexp.MustCompile(`^prompt_(\d{4,})(?:_to_(\d{4,}))?_analysis\.md(?:\.gz)?$`),
+	regexp.MustCompile(`^prompt_(\d{4,}(?:_\d{4,})+)_analysis\.md(?:\.gz)?$`),
+	regexp.MustCompile(`^regression_analysis_(\d{4,})\.md(?:\.gz)?$`),
+	regexp.MustCompile(`^regression_(?:causal|cumulative)_(\d{4,})_to_(?:(\d{4,})|current)\.json(?:\.gz)?$`),
+}
+
+// Write a JSON or Markdown report, gzipped with a .gz suffix under --gzip-artifacts.
+// Returns the path actually written.
+func (e *engine) writeArtifact(path string, content []byte) (string, error) {
+	if !e.gzipArtifacts {
+		return path, os.WriteFile(path, content, 0644)
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(content); err != nil {
+		return path, err
+	}
+	if err := gz.Close(); err != nil {
+		return path, err
+	}
+	path += ".gz"
+	return path, os.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// Read a report written by writeArtifact, decompressing it when the name ends in .gz
+func readArtifact(path string) ([]byte, error) {
+	content, err := os.ReadFile(path)
+	if err != nil || !strings.HasSuffix(path, ".gz") {
+		return content, err
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(content))
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	return io.ReadAll(gz)
+}
+
+// Choose snapshots to prune: everything but the newest keep, limited to those created more than
+// olderThan ago when set (0 disables either bound). Snapshots that a surviving incremental snapshot
+// still reads files from are held back; held maps each to the snapshot that needs it.
+func planPrune(snapshotsRoot string, keep int, olderThan time.Duration) ([]int, map[int]int) {
+	indices := listSnapshotIndices(snapshotsRoot)
+	now := time.Now()
+	candidates := make(map[int]bool)
+	for i, index := range indices {
+		if i >= len(indices)-keep {
+			break
+		}
+		if olderThan > 0 {
+			created, err := snapshotCreatedAt(snapshotsRoot, findSnapshotByIndex(snapshotsRoot, index))
+			if err != nil || withinAgeWindow(created, now, olderThan, 0) {
+				continue
+			}
+		}
+		candidates[index] = true
+	}
+	return holdReferencedSnapshots(snapshotsRoot, indices, candidates)
+}
+
+// Choose snapshots a retention policy no longer covers, holding back referenced ones as planPrune does
+func planRetention(snapshotsRoot string, policy RetentionPolicy) ([]int, map[int]int) {
+	indices := listSnapshotIndices(snapshotsRoot)
+	keepLast := policy.KeepLast
+	if keepLast < 1 {
+		keepLast = 1 // Never prune the snapshot that was just taken
+	}
+	candidates := make(map[int]bool)
+	days := make(map[string]bool)
+	for i := len(indices) - 1; i >= 0; i-- {
+		index := indices[i]
+		if i >= len(indices)-keepLast {
+			continue
+		}
+		created, err := snapshotCreatedAt(snapshotsRoot, findSnapshotByIndex(snapshotsRoot, index))
+		if err != nil {
+			continue // Leave snapshots of unknown age alone
+		}
+		day := created.Local().Format("2006-01-02")
+		if !days[day] && len(days) < policy.KeepDaily {
+			days[day] = true
+			continue
+		}
+		candidates[index] = true
+	}
+	return holdReferencedSnapshots(snapshotsRoot, indices, candidates)
+}
+
+// Drop candidates that a surviving snapshot still reads files from; held maps each to the snapshot that needs it
+func holdReferencedSnapshots(snapshotsRoot string, indices []int, candidates map[int]bool) ([]int, map[int]int) {
+	// Keep anything a surviving snapshot refers to, repeating until nothing else is pulled back
+	held := make(map[int]int)
+	for changed := true; changed; {
+		changed = false
+		for _, index := range indices {
+			if candidates[index] {
+				continue
+			}
+			for _, entry := range loadFileIndex(filepath.Join(snapshotsRoot, findSnapshotByIndex(snapshotsRoot, index))) {
+				if entry.Ref != 0 && candidates[entry.Ref] {
+					delete(candidates, entry.Ref)
+					held[entry.Ref] = index
+					changed = true
+				}
+			}
+		}
+	}
+	
+	var remove []int
+	for _, index := range indices {
+		if candidates[index] {
+			remove = append(remove, index)
+		}
+	}
+	return remove, held
+}
+
+// Delete a pruned snapshot and record it in the change manifest
+func (e *engine) removeSnapshot(snapshotsRoot string, index int, folder string) error {
+	if err := os.RemoveAll(filepath.Join(snapshotsRoot, folder)); err != nil {
+		return err
+	}
+	if err := appendManifestEvent(snapshotsRoot, padNumber(index, SNAPSHOT_INDEX_WIDTH), "[PRUNED] "+folder); err != nil {
+		fmt.Fprintf(e.errOut, "⚠️  Failed to log pruning %s: %v\n", folder, err)
+	}
+	return nil
+}
+
+// Apply the .snapshotrc retention policy after a new snapshot, reporting what was removed
+func (e *engine) applyRetention(snapshotsRoot string, policy RetentionPolicy) {
+	remove, _ := planRetention(snapshotsRoot, policy)
+	for _, index := range remove {
+		folder := findSnapshotByIndex(snapshotsRoot, index)
+		if err := e.removeSnapshot(snapshotsRoot, index, folder); err != nil {
+			fmt.Fprintf(e.errOut, "⚠️  Retention: failed to remove %s: %v\n", folder, err)
+			continue
+		}
+		fmt.Fprintf(e.out, "   🧹 Auto-pruned %s (retention policy)\n", folder)
+	}
+}
+
+// Find generated artifacts that refer to a snapshot which no longer exists; returns their names and total size
+func findOrphanedArtifacts(snapshotsRoot string) ([]string, int64, error) {
+	entries, err := os.ReadDir(snapshotsRoot)
+	if os.IsNotExist(err) {
+		// Nothing has been written yet (a --dry-run doesn't create the directory)
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	
+	existing := make(map[int]bool)
+	for _, index := range listSnapshotIndices(snapshotsRoot) {
+		existing[index] = true
+	}
+	
+	var orphans []string
+	var size int64
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		for _, pattern := range artifactPatterns {
+			match := pattern.FindStringSubmatch(entry.Name())
+			if match == nil {
+				continue
+			}
+			orphaned := false
+			for _, group := range match[1:] {
+				// Multi-base prompts name every base snapshot, joined with "_"
+				for _, part := range strings.Split(group, "_") {
+					if index, err := strconv.Atoi(part); err == nil && !existing[index] {
+						orphaned = true
+					}
+				}
+			}
+			if orphaned {
+				orphans = append(orphans, entry.Name())
+				if info, err := entry.Info(); err == nil {
+					size += info.Size()
+				}
+			}
+			break
+		}
+	}
+	return orphans, size, nil
+}
+
+// Regenerate snapshot.log from the snapshots on disk, backing up any existing log first
+func (e *engine) rebuildChangeManifest(snapshotsRoot string, ignoreSet *IgnoreSet) (int, string, error) {
+	logPath := filepath.Join(snapshotsRoot, "snapshot.log")
+	backupPath := ""
+	if _, err := os.Stat(logPath); err == nil {
+		backupPath = logPath + ".bak"
+		if err := os.Rename(logPath, backupPath); err != nil {
+			return 0, "", err
+		}
+	}
+	
+	var entries, markdownEntries []string
+	var records []ManifestRecord
+	indices := listSnapshotIndices(snapshotsRoot)
+	for _, index := range indices {
+		folder := findSnapshotByIndex(snapshotsRoot, index)
+		createdAt, err := snapshotCreatedAt(snapshotsRoot, folder)
+		if err != nil {
+			return 0, backupPath, err
+		}
+		label := snapshotLabel(snapshotsRoot, folder)
+		initial, sections, files, err := e.collectManifestSections(snapshotsRoot, filepath.Join(snapshotsRoot, folder), index, ignoreSet)
+		if err != nil {
+			return 0, backupPath, err
+		}
+		entries = append(entries, e.formatManifestEntry(index, label, createdAt, initial, sections))
+		records = append(records, newManifestRecord(index, label, createdAt, files))
+		markdownEntries = append(markdownEntries, e.formatMarkdownManifestEntry(index, label, createdAt, initial, sections))
+	}
+	
+	// manifest.json and the Markdown log are regenerated in full too; only snapshot.log is backed up
+	if err := writeManifestRecords(snapshotsRoot, records); err != nil {
+		return 0, backupPath, err
+	}
+	if e.markdownLog {
+		if err := os.WriteFile(filepath.Join(snapshotsRoot, MARKDOWN_LOG_FILE), []byte(strings.Join(markdownEntries, "")), 0644); err != nil {
+			return 0, backupPath, err
+		}
+	}
+	return len(indices), backupPath, os.WriteFile(logPath, []byte(strings.Join(entries, "")), 0644)
+}
+
+// ManifestEntry is one snapshot's block in snapshot.log
+type ManifestEntry struct {
+	Header string   // [NNNN] timestamp - "label"
+	Body   []string // Changed/Added/Removed/Renamed sections
+}
+
+// Split snapshot.log into entries at the "----" separators
+func parseChangeManifest(content string) []ManifestEntry {
+	var entries []ManifestEntry
+	var current []string
+	flush := func() {
+		// Drop surrounding blank lines; the first remaining line is the header
+		for len(current) > 0 && strings.TrimSpace(current[0]) == "" {
+			current = current[1:]
+		}
+		for len(current) > 0 && strings.TrimSpace(current[len(current)-1]) == "" {
+			current = current[:len(current)-1]
+		}
+		if len(current) > 0 {
+			entries = append(entries, ManifestEntry{Header: current[0], Body: current[1:]})
+		}
+		current = nil
+	}
+	
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.HasPrefix(line, "----------") {
+			flush()
+			continue
+		}
+		current = append(current, line)
+	}
+	flush()
+	return entries
+}
+
+// Print the change manifest, newest first, optionally one line per entry and limited to the last N
+func (e *engine) printChangeManifest(snapshotsRoot string, oneline bool, limit int) error {
+	content, err := os.ReadFile(filepath.Join(snapshotsRoot, "snapshot.log"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			fmt.Fprintln(e.out, "📭 No snapshot log yet.")
+			return nil
+		}
+		return err
+	}
+	
+	entries := parseChangeManifest(string(content))
+	if limit > 0 && limit < len(entries) {
+		entries = entries[len(entries)-limit:]
+	}
+	
+	var lines []string
+	for i := len(entries) - 1; i >= 0; i-- {
+		lines = append(lines, e.colorize(entries[i].Header, COLOR_YELLOW))
+		if !oneline {
+			lines = append(lines, entries[i].Body...)
+			if i > 0 {
+				lines = append(lines, "")
+			}
+		}
+	}
+	fmt.Fprintln(e.out, strings.Join(lines, "\n"))
+	return nil
+}
+
+// Format a git-style --stat summary with a proportional histogram per file
+func formatDiffStat(diffData *DiffResult) []string {
+	const maxBarWidth = 50
+	
+	names := make([]string, len(diffData.Files))
+	nameWidth := 0
+	maxChanges := 0
+	for i, file := range diffData.Files {
+		names[i] = file.File
+		if file.RenamedFrom != "" {
+			names[i] = file.RenamedFrom + " => " + file.File
+		}
+		if len(names[i]) > nameWidth {
+			nameWidth = len(names[i])
+		}
+		changes := 0
+		if file.Insertions != nil {
+			changes += *file.Insertions
+		}
+		if file.Deletions != nil {
+			changes += *file.Deletions
+		}
+		if changes > maxChanges {
+			maxChanges = changes
+		}
+	}
+	countWidth := len(strconv.Itoa(maxChanges))
+	
+	var lines []string
+	var totalInsertions, totalDeletions int
+	for i, file := range diffData.Files {
+		var insertions, deletions int
+		if file.Insertions != nil {
+			insertions = *file.Insertions
+		}
+		if file.Deletions != nil {
+			deletions = *file.Deletions
+		}
+		totalInsertions += insertions
+		totalDeletions += deletions
+		
+		// Scale the bar down only when the largest change would overflow it
+		plus, minus := insertions, deletions
+		if maxChanges > maxBarWidth {
+			plus = scaleStat(insertions, maxChanges, maxBarWidth)
+			minus = scaleStat(deletions, maxChanges, maxBarWidth)
+		}
+		bar := strings.Repeat("+", plus) + strings.Repeat("-", minus)
+		lines = append(lines, fmt.Sprintf(" %-*s | %*d %s", nameWidth, names[i], countWidth, insertions+deletions, bar))
+	}
+	
+	lines = append(lines, fmt.Sprintf(" %d file%s changed, %d insertion%s(+), %d deletion%s(-)",
+		len(diffData.Files), plural(len(diffData.Files)),
+		totalInsertions, plural(totalInsertions),
+		totalDeletions, plural(totalDeletions)))
+	return lines
+}
+
+// Scale a change count onto the histogram width, keeping non-zero counts visible
+func scaleStat(count, maxChanges, width int) int {
+	if count == 0 {
+		return 0
+	}
+	scaled := count * width / maxChanges
+	if scaled == 0 {
+		scaled = 1
+	}
+	return scaled
+}
+
+// Return "s" for counts other than one
+func plural(count int) string {
+	if count == 1 {
+		return ""
+	}
+	return "s"
+}
+
+// Extract the hunk lines of a unified diff for embedding in a prompt
+func cleanDiffLines(diff string) []string {
+	var cleanDiff []string
+	inContent := false
+	
+	for _, line := range strings.Split(diff, "\n") {
+		if strings.HasPrefix(line, "@@") {
+			inContent = true
+			cleanDiff = append(cleanDiff, line)
+		} else if inContent && (strings.HasPrefix(line, "+") || strings.HasPrefix(line, "-") || strings.HasPrefix(line, " ")) {
+			cleanDiff = append(cleanDiff, line)
+		}
+	}
+	return cleanDiff
+}
+
+// Approximate the LLM token count of a prompt (~4 characters per token)
+func estimateTokens(content string) int {
+	return (len(content) + 3) / 4
+}
+
+// ANSI color codes for terminal output
+const (
+	COLOR_RED    = "\033[31m"
+	COLOR_GREEN  = "\033[32m"
+	COLOR_YELLOW = "\033[33m"
+	COLOR_CYAN   = "\033[36m"
+	COLOR_RESET  = "\033[0m"
+)
+
+// Check whether a stream is a file attached to a terminal
+func isTerminal(stream any) bool {
+	f, ok := stream.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// progressReporter shows an in-place "Copying 1200/5000 files..." line on a terminal
+type progressReporter struct {
+	verb    string
+	total   int
+	done    int
+	enabled bool
+	shown   time.Time
+	mu      sync.Mutex
+	out     io.Writer
+}
+
+// Number of workers to use for file operations
+func (e *engine) workerCount() int {
+	if e.concurrency > 0 {
+		return e.concurrency
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+// Run fn for 0..n-1 on at most workerCount() goroutines, stopping at the first error.
+// With a single worker items run in order on the calling goroutine.
+func (e *engine) forEachParallel(n int, fn func(i int) error) error {
+	workers := e.workerCount()
+	if workers == 1 || n <= 1 {
+		for i := 0; i < n; i++ {
+			if err := fn(i); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if workers > n {
+		workers = n
+	}
+	
+	errs := make([]error, n)
+	var failed atomic.Bool
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if errs[i] = fn(i); errs[i] != nil {
+					failed.Store(true)
+				}
+			}
+		}()
+	}
+	for i := 0; i < n && !failed.Load(); i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	
+	// Report the earliest failure, as a sequential run would
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Start progress reporting; silent when stdout isn't a terminal or --quiet is set
+func (e *engine) newProgress(verb string, total int) *progressReporter {
+	return &progressReporter{
+		verb:    verb,
+		total:   total,
+		enabled: !e.quietMode && total > 0 && isTerminal(e.out),
+		out:     e.out,
+	}
+}
+
+// Count one finished file, redrawing at most every 100ms
+func (p *progressReporter) Increment() {
+	if p == nil || !p.enabled {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done++
+	if p.done < p.total && time.Since(p.shown) < 100*time.Millisecond {
+		return
+	}
+	p.shown = time.Now()
+	fmt.Fprintf(p.out, "\r   %s %d/%d files...", p.verb, p.done, p.total)
+}
+
+// Clear the progress line so normal output continues cleanly (the next Increment redraws it)
+func (p *progressReporter) Clear() {
+	if p == nil || !p.enabled || p.shown.IsZero() {
+		return
+	}
+	fmt.Fprint(p.out, "\r\033[K")
+}
+
+// Colors are used only on a terminal and when NO_COLOR is unset
+func (e *engine) useColor() bool {
+	return os.Getenv("NO_COLOR") == "" && isTerminal(e.out)
+}
+
+// Wrap text in an ANSI color when colors are enabled
+func (e *engine) colorize(text, color string) string {
+	if !e.useColor() {
+		return text
+	}
+	return color + text + COLOR_RESET
+}
+
+// Print a git-status style summary of drift from a snapshot
+func (e *engine) printStatus(diffData *DiffResult) {
+	groups := []struct {
+		status string
+		title  string
+		color  string
+	}{
+		{"modified", "Modified", COLOR_YELLOW},
+		{"added", "Added", COLOR_GREEN},
+		{"removed", "Removed", COLOR_RED},
+		{"renamed", "Renamed", COLOR_CYAN},
+		{"error_comparing", "Unreadable", COLOR_RED},
+	}
+	
+	for _, group := range groups {
+		var entries []string
+		for _, file := range diffData.Files {
+			if file.Status != group.status {
+				continue
+			}
+			if file.RenamedFrom != "" {
+				entries = append(entries, file.RenamedFrom+" -> "+file.File)
+			} else {
+				entries = append(entries, file.File)
+			}
+		}
+		if len(entries) == 0 {
+			continue
+		}
+		
+		fmt.Fprintf(e.out, "%s (%d):\n", group.title, len(entries))
+		for _, entry := range entries {
+			fmt.Fprintln(e.out, "  "+e.colorize(entry, group.color))
+		}
+		fmt.Fprintln(e.out, "")
+	}
+}
+
+// Summarize a diff for the terminal: added green, removed red, modified yellow with line counts
+func (e *engine) formatDiffSummary(diffData *DiffResult) []string {
+	var lines []string
+	var added, removed, modified int
+	for _, file := range diffData.Files {
+		switch file.Status {
+		case "added":
+			added++
+			lines = append(lines, e.colorize("  A  "+file.File, COLOR_GREEN))
+		case "removed":
+			removed++
+			lines = append(lines, e.colorize("  D  "+file.File, COLOR_RED))
+		case "modified":
+			modified++
+			ins, del := 0, 0
+			if file.Insertions != nil {
+				ins = *file.Insertions
+			}
+			if file.Deletions != nil {
+				del = *file.Deletions
+			}
+			if file.SizeDelta != nil {
+				sign, delta := "+", *file.SizeDelta
+				if delta < 0 {
+					sign, delta = "-", -delta
+				}
+				lines = append(lines, e.colorize(fmt.Sprintf("  M  %s (too large to diff, %s%s)", file.File, sign, formatBytes(delta)), COLOR_YELLOW))
+				continue
+			}
+			if file.Message != "" {
+				lines = append(lines, e.colorize(fmt.Sprintf("  M  %s (%s)", file.File, strings.ToLower(file.Message[:1])+file.Message[1:]), COLOR_YELLOW))
+				continue
+			}
+			lines = append(lines, e.colorize(fmt.Sprintf("  M  %s (+%d -%d)", file.File, ins, del), COLOR_YELLOW))
+		case "renamed":
+			lines = append(lines, e.colorize("  R  "+file.RenamedFrom+" -> "+file.File, COLOR_CYAN))
+		case "error_comparing":
+			lines = append(lines, e.colorize("  !  "+file.File+" (could not be compared)", COLOR_RED))
+		}
+	}
+	
+	if len(lines) == 0 {
+		return []string{"No differences."}
+	}
+	lines = append(lines, "")
+	lines = append(lines, fmt.Sprintf("%d modified, %d added, %d removed", modified, added, removed))
+	return lines
+}
+
+// List changed paths one per line; withStatus prefixes a git-style letter (A/M/D/R) and a tab
+func formatNameList(diffData *DiffResult, withStatus bool) []string {
+	var lines []string
+	for _, file := range diffData.Files {
+		if !withStatus {
+			lines = append(lines, file.File)
+			continue
+		}
+		switch file.Status {
+		case "added":
+			lines = append(lines, "A\t"+file.File)
+		case "removed":
+			lines = append(lines, "D\t"+file.File)
+		case "modified":
+			lines = append(lines, "M\t"+file.File)
+		case "renamed":
+			lines = append(lines, "R\t"+file.RenamedFrom+"\t"+file.File)
+		case "error_comparing":
+			lines = append(lines, "!\t"+file.File)
+		}
+	}
+	return lines
+}
+
+// Parse an --only-status list such as "added,removed" or "A,D" into diff statuses
+func parseStatusFilter(value string, statuses map[string]bool) error {
+	for _, part := range strings.Split(value, ",") {
+		switch strings.ToLower(strings.TrimSpace(part)) {
+		case "added", "a":
+			statuses["added"] = true
+		case "modified", "m":
+			statuses["modified"] = true
+		case "removed", "d":
+			statuses["removed"] = true
+		case "renamed", "r":
+			statuses["renamed"] = true
+		default:
+			return fmt.Errorf("unknown status %q (use added, modified, removed, renamed or A/M/D/R)", part)
+		}
+	}
+	return nil
+}
+
+// Keep only the entries whose status is in statuses, updating the summary to match
+func filterDiffByStatus(diffData *DiffResult, statuses map[string]bool) {
+	files := []DiffFile{}
+	for _, file := range diffData.Files {
+		if statuses[file.Status] {
+			files = append(files, file)
+		}
+	}
+	diffData.Files = files
+	diffData.Summary = summarizeDiff(files)
+}
+
+// Print output through $PAGER (default "less -FRX") on a terminal, directly otherwise
+func (e *engine) printPaged(lines []string) {
+	content := strings.Join(lines, "\n") + "\n"
+	if !isTerminal(e.out) || len(lines) < 20 {
+		fmt.Fprint(e.out, content)
+		return
+	}
+	
+	pager := os.Getenv("PAGER")
+	if pager == "" {
+		pager = "less -FRX"
+	}
+	fields := strings.Fields(pager)
+	cmd := exec.Command(fields[0], fields[1:]...)
+	cmd.Stdin = strings.NewReader(content)
+	cmd.Stdout = e.out
+	cmd.Stderr = e.errOut
+	if err := cmd.Run(); err != nil {
+		fmt.Fprint(e.out, content)
+	}
+}
+
+// Open a generated file with $EDITOR, or the OS default application when it isn't set
+func (e *engine) openInEditor(path string) {
+	if e.quietMode {
+		fmt.Fprintf(e.errOut, "ℹ️  --open skipped in --quiet mode: %s\n", path)
+		return
+	}
+	if strings.HasSuffix(path, ".gz") {
+		fmt.Fprintf(e.errOut, "ℹ️  --open skipped for a compressed report: %s\n", path)
+		return
+	}
+	
+	var command []string
+	if editor := strings.Fields(os.Getenv("EDITOR")); len(editor) > 0 {
+		command = editor
+	} else {
+		switch runtime.GOOS {
+		case "darwin":
+			command = []string{"open"}
+		case "windows":
+			command = []string{"cmd", "/c", "start", ""}
+		default:
+			command = []string{"xdg-open"}
+		}
+	}
+	if _, err := exec.LookPath(command[0]); err != nil {
+		fmt.Fprintf(e.errOut, "ℹ️  --open: %s not found (set $EDITOR); open %s yourself\n", command[0], path)
+		return
+	}
+	
+	// Terminal editors need the terminal, so wait for them to exit
+	cmd := exec.Command(command[0], append(command[1:], path)...)
+	cmd.Stdin = e.stdin
+	cmd.Stdout = e.out
+	cmd.Stderr = e.errOut
+	if err := cmd.Run(); err != nil {
+		fmt.Fprintf(e.errOut, "⚠️  --open: %s failed: %v\n", command[0], err)
+	}
+}
+
+// Render a diff result as a standalone HTML report
+func renderHTMLReport(diffData *DiffResult) string {
+	summary := summarizeDiff(diffData.Files)
+	
+	var lines []string
+	lines = append(lines, "<!DOCTYPE html>")
+	lines = append(lines, "<html>")
+	lines = append(lines, "<head>")
+	lines = append(lines, "<meta charset=\"utf-8\">")
+	lines = append(lines, fmt.Sprintf("<title>Diff: %s → %s</title>", html.EscapeString(diffData.Base), html.EscapeString(diffData.Compare)))
+	lines = append(lines, "<style>")
+	lines = append(lines, "body { font-family: sans-serif; margin: 2em; }")
+	lines = append(lines, "summary { cursor: pointer; font-family: monospace; padding: 0.2em 0; }")
+	lines = append(lines, "pre { background: #f6f8fa; padding: 0.5em; overflow-x: auto; }")
+	lines = append(lines, ".line { display: block; }")
+	lines = append(lines, ".add { background: #e6ffed; color: #22863a; }")
+	lines = append(lines, ".del { background: #ffeef0; color: #b31d28; }")
+	lines = append(lines, ".hunk { color: #6f42c1; }")
+	lines = append(lines, ".status-added { color: #22863a; }")
+	lines = append(lines, ".status-removed { color: #b31d28; }")
+	lines = append(lines, ".status-modified { color: #b08800; }")
+	lines = append(lines, ".status-renamed { color: #0366d6; }")
+	lines = append(lines, "</style>")
+	lines = append(lines, "</head>")
+	lines = append(lines, "<body>")
+	lines = append(lines, fmt.Sprintf("<h1>%s → %s</h1>", html.EscapeString(diffData.Base), html.EscapeString(diffData.Compare)))
+	lines = append(lines, fmt.Sprintf("<p class=\"summary\">%d file(s) changed: %d modified, %d added, %d removed, %d renamed</p>", len(diffData.Files), summary.Modified, summary.Added, summary.Removed, summary.Renamed))
+	
+	for _, file := range diffData.Files {
+		status := html.EscapeString(file.Status)
+		heading := fmt.Sprintf("<span class=\"status-%s\">[%s]</span> %s", status, strings.ToUpper(status), html.EscapeString(file.File))
+		if file.RenamedFrom != "" {
+			heading = fmt.Sprintf("<span class=\"status-%s\">[%s]</span> %s → %s", status, strings.ToUpper(status), html.EscapeString(file.RenamedFrom), html.EscapeString(file.File))
+		}
+		if file.LinesChanged != nil {
+			heading += fmt.Sprintf(" (%d lines changed)", *file.LinesChanged)
+		}
+		
+		lines = append(lines, "<details>")
+		lines = append(lines, "<summary>"+heading+"</summary>")
+		if file.Message != "" {
+			lines = append(lines, "<p>"+html.EscapeString(file.Message)+"</p>")
+		}
+		if file.Diff != "" {
+			lines = append(lines, "<pre>")
+			for _, line := range strings.Split(file.Diff, "\n") {
+				class := "line"
+				if strings.HasPrefix(line, "@@") {
+					class += " hunk"
+				} else if strings.HasPrefix(line, "+") && !strings.HasPrefix(line, "+++") {
+					class += " add"
+				} else if strings.HasPrefix(line, "-") && !strings.HasPrefix(line, "---") {
+					class += " del"
+				}
+				lines = append(lines, fmt.Sprintf("<span class=\"%s\">%s</span>", class, html.EscapeString(line)))
+			}
+			lines = append(lines, "</pre>")
+		}
+		lines = append(lines, "</details>")
+	}
+	
+	lines = append(lines, "</body>")
+	lines = append(lines, "</html>")
+	lines = append(lines, "")
+	return strings.Join(lines, "\n")
+}
+
+// Save HTML diff report
+func (e *engine) saveHTMLReport(diffData *DiffResult, outputPath string) error {
+	err := os.WriteFile(outputPath, []byte(renderHTMLReport(diffData)), 0644)
+	if err == nil {
+		fmt.Fprintf(e.out, "✅ HTML report saved to %s\n", outputPath)
+	}
+	return err
+}
+
+// Load a custom prompt template from --template or .snapshot-prompt.md (empty means built-in)
+func loadPromptTemplate(projectRoot, templatePath string) (string, error) {
+	if templatePath == "" {
+		templatePath = filepath.Join(projectRoot, PROMPT_TEMPLATE_FILE)
+		if _, err := os.Stat(templatePath); os.IsNotExist(err) {
+			return "", nil
+		}
+	}
+	
+	content, err := os.ReadFile(templatePath)
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+// Fill {{PLACEHOLDER}} markers in a prompt template
+func applyPromptTemplate(template string, values map[string]string) string {
+	var pairs []string
+	for key, value := range values {
+		pairs = append(pairs, "{{"+key+"}}", value)
+	}
+	return strings.NewReplacer(pairs...).Replace(template)
+}
+
+// Append a one-line event (rename, import) to snapshot.log
+func appendManifestEvent(snapshotsRoot, prefix, event string) error {
+	timestamp := time.Now().Format("2006-01-02 15:04:05")
+	var lines []string
+	lines = append(lines, fmt.Sprintf("[%s] %s - %s", prefix, timestamp, event))
+	lines = append(lines, "")
+	lines = append(lines, "----------------------------------------")
+	lines = append(lines, "")
+	
+	f, err := os.OpenFile(filepath.Join(snapshotsRoot, "snapshot.log"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	
+	_, err = f.WriteString(strings.Join(lines, "\n"))
+	return err
+}
+
+// Rename a snapshot's label while keeping its index prefix
+func (e *engine) renameSnapshot(snapshotsRoot string, index int, newLabel string) error {
+	oldFolder := findSnapshotByIndex(snapshotsRoot, index)
+	if oldFolder == "" {
+		return fmt.Errorf("snapshot folder not found for index %s", padNumber(index, SNAPSHOT_INDEX_WIDTH))
+	}
+	
+	prefix := strings.SplitN(oldFolder, "_", 2)[0]
+	newFolder := prefix + "_" + sanitizeLabel(newLabel)
+	newPath := filepath.Join(snapshotsRoot, newFolder)
+	if _, err := os.Stat(newPath); err == nil {
+		return fmt.Errorf("a snapshot named %s already exists", newFolder)
+	}
+	
+	if err := os.Rename(filepath.Join(snapshotsRoot, oldFolder), newPath); err != nil {
+		return err
+	}
+	
+	// Keep any stored metadata in sync with the new label
+	metaPath := filepath.Join(newPath, SNAPSHOT_META_FILE)
+	if content, err := os.ReadFile(metaPath); err == nil {
+		meta := make(map[string]interface{})
+		if err := json.Unmarshal(content, &meta); err == nil {
+			meta["label"] = newLabel
+			metaJSON, _ := json.MarshalIndent(meta, "", "  ")
+			if err := os.WriteFile(metaPath, metaJSON, 0644); err != nil {
+				return err
+			}
+		}
+	}
+	
+	if err := appendManifestEvent(snapshotsRoot, prefix, fmt.Sprintf("[RENAMED] %s -> %s", oldFolder, newFolder)); err != nil {
+		return err
+	}
+	
+	fmt.Fprintf(e.out, "✅ Renamed %s → %s\n", oldFolder, newFolder)
+	return nil
+}
+
+// Re-copy the working tree into the latest snapshot, replacing its contents and its snapshot.log entry.
+// The first snapshot is only amended with force, since nothing else records that state.
+func (e *engine) amendLatestSnapshot(snapshotsRoot, projectRoot string, ignoreSet *IgnoreSet, force bool) (string, CopyStats, error) {
+	var stats CopyStats
+	indices := listSnapshotIndices(snapshotsRoot)
+	if len(indices) == 0 {
+		return "", stats, fmt.Errorf("no snapshots to amend")
+	}
+	index := indices[len(indices)-1]
+	if len(indices) == 1 && !force {
+		return "", stats, fmt.Errorf("refusing to amend the first snapshot (use --force)")
+	}
+	folder := findSnapshotByIndex(snapshotsRoot, index)
+	snapshotPath := filepath.Join(snapshotsRoot, folder)
+	prefix := strings.SplitN(folder, "_", 2)[0]
+	
+	// Build the new contents beside the old ones, then swap them in
+	tempDir := filepath.Join(snapshotsRoot, ".tmp-"+prefix)
+	os.RemoveAll(tempDir)
+	if err := os.MkdirAll(tempDir, 0755); err != nil {
+		return "", stats, err
+	}
+	fail := func(err error) (string, CopyStats, error) {
+		os.RemoveAll(tempDir)
+		return "", stats, err
+	}
+	
+	files, err := e.listFilesRecursively(projectRoot, projectRoot, ignoreSet)
+	if err != nil {
+		return fail(err)
+	}
+	progress := e.newProgress("Copying", len(files))
+	stats, err = e.copyDir(projectRoot, tempDir, ignoreSet, projectRoot, nil, progress)
+	progress.Clear()
+	if err != nil {
+		return fail(err)
+	}
+	if err := writeFileIndex(tempDir, stats.Index); err != nil {
+		return fail(err)
+	}
+	
+	// Keep the label; everything else describes the new contents
+	meta, _ := loadSnapshotMeta(snapshotPath)
+	if meta.Label == "" {
+		meta.Label = snapshotLabel(snapshotsRoot, folder)
+	}
+	sort.Strings(stats.EmptyDirs)
+	meta = SnapshotMeta{Label: meta.Label, CreatedAt: time.Now(), EmptyDirs: stats.EmptyDirs, Partial: e.maxDepth >= 0}
+	if commit, dirty, ok := gitState(projectRoot); ok {
+		meta.GitCommit, meta.GitDirty = commit, dirty
+	}
+	if err := writeSnapshotMeta(tempDir, meta); err != nil {
+		return fail(err)
+	}
+	
+	oldDir := filepath.Join(snapshotsRoot, ".amend-"+prefix)
+	os.RemoveAll(oldDir)
+	if err := os.Rename(snapshotPath, oldDir); err != nil {
+		return fail(err)
+	}
+	if err := os.Rename(tempDir, snapshotPath); err != nil {
+		os.Rename(oldDir, snapshotPath)
+		return fail(err)
+	}
+	os.RemoveAll(oldDir)
+	
+	return folder, stats, e.replaceChangeManifest(snapshotsRoot, snapshotPath, index, meta.Label, meta.CreatedAt, ignoreSet)
+}
+
+// Rewrite the change manifest entries of a snapshot whose contents were replaced
+func (e *engine) replaceChangeManifest(snapshotsRoot, snapshotPath string, index int, label string, createdAt time.Time, ignoreSet *IgnoreSet) error {
+	initial, sections, changes, err := e.collectManifestSections(snapshotsRoot, snapshotPath, index, ignoreSet)
+	if err != nil {
+		return err
+	}
+	if err := saveManifestRecord(snapshotsRoot, newManifestRecord(index, label, createdAt, changes)); err != nil {
+		return err
+	}
+	prefix := padNumber(index, SNAPSHOT_INDEX_WIDTH)
+	if err := replaceManifestEntry(snapshotsRoot, prefix, e.formatManifestEntry(index, label, createdAt, initial, sections)); err != nil {
+		return err
+	}
+	if e.markdownLog {
+		return replaceMarkdownManifestEntry(snapshotsRoot, prefix, e.formatMarkdownManifestEntry(index, label, createdAt, initial, sections))
+	}
+	return nil
+}
+
+// Replace the last snapshot-log.md entry for a snapshot, appending if there is none
+func replaceMarkdownManifestEntry(snapshotsRoot, prefix, entry string) error {
+	logPath := filepath.Join(snapshotsRoot, MARKDOWN_LOG_FILE)
+	content, err := os.ReadFile(logPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	
+	// Each entry runs from its "## NNNN — " heading to the next heading
+	var blocks []string
+	for _, line := range strings.SplitAfter(string(content), "\n") {
+		if strings.HasPrefix(line, "## ") || len(blocks) == 0 {
+			blocks = append(blocks, line)
+		} else {
+			blocks[len(blocks)-1] += line
+		}
+	}
+	replaced := false
+	for i := len(blocks) - 1; i >= 0; i-- {
+		if strings.HasPrefix(blocks[i], "## "+prefix+" — ") {
+			blocks[i] = entry
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		blocks = append(blocks, entry)
+	}
+	return os.WriteFile(logPath, []byte(strings.Join(blocks, "")), 0644)
+}
+
+// Replace the last snapshot.log entry for a snapshot (not rename/import events), appending if there is none
+func replaceManifestEntry(snapshotsRoot, prefix, entry string) error {
+	logPath := filepath.Join(snapshotsRoot, "snapshot.log")
+	content, err := os.ReadFile(logPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	
+	const separator = "----------------------------------------\n"
+	blocks := strings.SplitAfter(string(content), separator)
+	replaced := false
+	for i := len(blocks) - 1; i >= 0; i-- {
+		header := strings.TrimSpace(blocks[i])
+		if strings.HasPrefix(header, "["+prefix+"] ") && strings.Contains(strings.SplitN(header, "\n", 2)[0], " - \"") {
+			// Keep any blank lines before the entry so the layout of the log is unchanged
+			lead := blocks[i][:len(blocks[i])-len(strings.TrimLeft(blocks[i], "\r\n"))]
+			blocks[i] = lead + strings.TrimSuffix(entry, "\n")
+			if !strings.HasSuffix(blocks[i], "\n") {
+				blocks[i] += "\n"
+			}
+			replaced = true
+			break
+		}
+	}
+	if !replaced {
+		blocks = append(blocks, entry)
+	}
+	return os.WriteFile(logPath, []byte(strings.Join(blocks, "")), 0644)
+}
+
+// PromptManifest is the JSON footer appended to generated prompts so tools can see
+// which files and roughly how many tokens a prompt holds without parsing its prose
+type PromptManifest struct {
+	Sections     []PromptManifestSection `json:"sections"`
+	ApproxTokens int                     `json:"approx_tokens"` // chars/4 of the prompt above the footer
+}
+
+// PromptManifestSection lists the files of one diff in a prompt, by status
+type PromptManifestSection struct {
+	Base       string               `json:"base"`
+	Compare    string               `json:"compare"`
+	Added      []string             `json:"added"`
+	Modified   []string             `json:"modified"`
+	Removed    []string             `json:"removed"`
+	Renamed    []PromptManifestMove `json:"renamed"`
+	Insertions int                  `json:"insertions"`
+	Deletions  int                  `json:"deletions"`
+	Churn      int                  `json:"churn"` // insertions + deletions
+}
+
+// PromptManifestMove is a renamed file in a prompt manifest
+type PromptManifestMove struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Append the fenced JSON manifest footer describing the diffs a prompt was built from
+func appendPromptManifest(content string, diffs ...*DiffResult) string {
+	manifest := PromptManifest{Sections: []PromptManifestSection{}, ApproxTokens: estimateTokens(content)}
+	for _, diffData := range diffs {
+		section := PromptManifestSection{
+			Base:     diffData.Base,
+			Compare:  diffData.Compare,
+			Added:    []string{},
+			Modified: []string{},
+			Removed:  []string{},
+			Renamed:  []PromptManifestMove{},
+		}
+		for _, file := range diffData.Files {
+			switch file.Status {
+			case "added":
+				section.Added = append(section.Added, file.File)
+			case "modified":
+				section.Modified = append(section.Modified, file.File)
+			case "removed":
+				section.Removed = append(section.Removed, file.File)
+			case "renamed":
+				section.Renamed = append(section.Renamed, PromptManifestMove{From: file.RenamedFrom, To: file.File})
+			}
+		}
+		summary := summarizeDiff(diffData.Files)
+		section.Insertions, section.Deletions, section.Churn = summary.Insertions, summary.Deletions, summary.Churn
+		manifest.Sections = append(manifest.Sections, section)
+	}
+	
+	manifestJSON, _ := json.MarshalIndent(manifest, "", "  ")
+	footer := []string{"", "<!-- snapshot-manifest -->", "```json", string(manifestJSON), "```", ""}
+	return strings.TrimRight(content, "\n") + "\n" + strings.Join(footer, "\n")
+}
+
+// Save AI-ready prompt
+// compareIndex and compareName are empty when comparing against the current working directory.
+func (e *engine) savePrompt(diffData *DiffResult, index, snapshotName, compareIndex, compareName, snapshotDir string, maxTokens int, template string) (string, error) {
+	basePath := fmt.Sprintf("%s/%s_%s/", e.snapshotsDisplayPath(), index, snapshotName)
+	baseLabel := snapshotDisplayLabel(snapshotDir, index+"_"+snapshotName)
+	
+	// Wording for the compare side: the working directory or a later snapshot
+	comparePath := "current"
+	compareLabel := ""
+	compareWhere := "the current working directory"
+	removedNote := "was in snapshot, now deleted from current code"
+	addedNote := "new file, not in snapshot"
+	outputName := fmt.Sprintf("prompt_%s_analysis.md", index)
+	if compareIndex != "" {
+		comparePath = fmt.Sprintf("%s/%s_%s/", e.snapshotsDisplayPath(), compareIndex, compareName)
+		compareWhere = fmt.Sprintf("snapshot `%s`", comparePath)
+		compareLabel = snapshotDisplayLabel(snapshotDir, compareIndex+"_"+compareName)
+		removedNote = fmt.Sprintf("in snapshot %s, deleted by snapshot %s", index, compareIndex)
+		addedNote = fmt.Sprintf("new in snapshot %s, not in snapshot %s", compareIndex, index)
+		outputName = fmt.Sprintf("prompt_%s_to_%s_analysis.md", index, compareIndex)
+	}
+	
+	// Separate files by status (renames with edits also show their diff)
+	var removedFiles, addedFiles, modifiedFiles, renamedFiles []DiffFile
+	for _, file := range diffData.Files {
+		switch file.Status {
+		case "removed":
+			removedFiles = append(removedFiles, file)
+		case "added":
+			addedFiles = append(addedFiles, file)
+		case "modified":
+			modifiedFiles = append(modifiedFiles, file)
+		case "renamed":
+			renamedFiles = append(renamedFiles, file)
+			if file.Diff != "" {
+				modifiedFiles = append(modifiedFiles, file)
+			}
+		}
+	}
+	
+	// Build REMOVED files section
+	var removedSection []string
+	if len(removedFiles) > 0 {
+		removedSection = append(removedSection, "## [REMOVED] Files")
+		removedSection = append(removedSection, "")
+		removedSection = append(removedSection, fmt.Sprintf("The following files were deleted from %s (they exist in the base snapshot):", compareWhere))
+		removedSection = append(removedSection, "")
+		for _, file := range removedFiles {
+			removedSection = append(removedSection, fmt.Sprintf("- `%s` (%s)", file.File, removedNote))
+		}
+		removedSection = append(removedSection, "")
+	}
+	
+	// Build ADDED files section
+	var addedSection []string
+	if len(addedFiles) > 0 {
+		addedSection = append(addedSection, "## [ADDED] Files")
+		addedSection = append(addedSection, "")
+		addedSection = append(addedSection, fmt.Sprintf("The following files were created in %s (they do not exist in the base snapshot):", compareWhere))
+		addedSection = append(addedSection, "")
+		for _, file := range addedFiles {
+			addedSection = append(addedSection, fmt.Sprintf("- `%s` (%s)", file.File, addedNote))
+		}
+		addedSection = append(addedSection, "")
+	}
+	
+	// Build RENAMED files section
+	var renamedSection []string
+	if len(renamedFiles) > 0 {
+		renamedSection = append(renamedSection, "## [RENAMED] Files")
+		renamedSection = append(renamedSection, "")
+		renamedSection = append(renamedSection, fmt.Sprintf("The following files were moved or renamed between the base snapshot and %s:", compareWhere))
+		renamedSection = append(renamedSection, "")
+		for _, file := range renamedFiles {
+			note := "identical content"
+			if file.Diff != "" {
+				note = "with edits, see below"
+			}
+			renamedSection = append(renamedSection, fmt.Sprintf("- `%s` → `%s` (%s)", file.RenamedFrom, file.File, note))
+		}
+		renamedSection = append(renamedSection, "")
+	}
+	
+	// Pre-render each modified file's diff so oversized ones can be truncated
+	diffBodies := make([][]string, len(modifiedFiles))
+	omittedLines := make([]int, len(modifiedFiles))
+	for i, file := range modifiedFiles {
+		diffBodies[i] = cleanDiffLines(file.Diff)
+	}
+	
+	render := func() string {
+		// Build MODIFIED files section with detailed diffs
+		var modifiedSection []string
+		if len(modifiedFiles) > 0 {
+			modifiedSection = append(modifiedSection, "## [MODIFIED] Files")
+			modifiedSection = append(modifiedSection, "")
+			modifiedSection = append(modifiedSection, "The following files were modified with line-by-line changes:")
+			modifiedSection = append(modifiedSection, "")
+			
+			for i, file := range modifiedFiles {
+				if file.RenamedFrom != "" {
+					modifiedSection = append(modifiedSection, fmt.Sprintf("### `%s` (renamed from `%s`)", file.File, file.RenamedFrom))
+				} else {
+					modifiedSection = append(modifiedSection, fmt.Sprintf("### `%s`", file.File))
+				}
+				modifiedSection = append(modifiedSection, "")
+				if file.LinesChanged != nil {
+					modifiedSection = append(modifiedSection, fmt.Sprintf("**Lines changed:** %d", *file.LinesChanged))
+				}
+				if file.SizeDelta != nil {
+					modifiedSection = append(modifiedSection, fmt.Sprintf("*%s; no line diff included.*", file.Message))
+				}
+				modifiedSection = append(modifiedSection, "")
+				
+				if omittedLines[i] > 0 {
+					modifiedSection = append(modifiedSection, fmt.Sprintf("... diff truncated (%d lines omitted) ...", omittedLines[i]))
+				} else if file.Diff != "" {
+					modifiedSection = append(modifiedSection, "```diff")
+					modifiedSection = append(modifiedSection, diffBodies[i]...)
+					modifiedSection = append(modifiedSection, "```")
+				}
+				modifiedSection = append(modifiedSection, "")
+			}
+		}
+		
+		if template != "" {
+			return applyPromptTemplate(template, map[string]string{
+				"BASE":     basePath,
+				"COMPARE":  comparePath,
+				"REMOVED":  strings.Join(removedSection, "\n"),
+				"ADDED":    strings.Join(addedSection, "\n"),
+				"MODIFIED": strings.Join(modifiedSection, "\n"),
+				"RENAMED":  strings.Join(renamedSection, "\n"),
+			})
+		}
+		
+		var lines []string
+		lines = append(lines, "# Code Analysis Request: Identify Breaking Changes")
+		lines = append(lines, "")
+		if compareIndex != "" {
+			lines = append(lines, fmt.Sprintf("I have two snapshots of my code: a base snapshot (\"%s\") at `%s` and a later snapshot (\"%s\") at `%s`.", baseLabel, basePath, compareLabel, comparePath))
+			lines = append(lines, "Please analyze the changes between them to help identify what may have broken the functionality.")
+			lines = append(lines, "")
+			lines = append(lines, "**Context:** The base snapshot represents a known working state. The changes shown below represent")
+			lines = append(lines, "all modifications made between the base snapshot and the later snapshot.")
+			if gitNote := snapshotGitNote(snapshotDir, index+"_"+snapshotName); gitNote != "" {
+				lines = append(lines, fmt.Sprintf("The base snapshot was taken at %s.", gitNote))
+			}
+			if gitNote := snapshotGitNote(snapshotDir, compareIndex+"_"+compareName); gitNote != "" {
+				lines = append(lines, fmt.Sprintf("The later snapshot was taken at %s.", gitNote))
+			}
+		} else {
+			lines = append(lines, fmt.Sprintf("I have a working snapshot of my code (\"%s\") located at `%s` and my current code has a regression.", baseLabel, basePath))
+			lines = append(lines, "Please analyze the changes below to help identify what may have broken the functionality.")
+			lines = append(lines, "")
+			lines = append(lines, "**Context:** The snapshot represents a known working state. The changes shown below represent")
+			lines = append(lines, "all modifications made since that working version.")
+			if gitNote := snapshotGitNote(snapshotDir, index+"_"+snapshotName); gitNote != "" {
+				lines = append(lines, fmt.Sprintf("The snapshot was taken at %s.", gitNote))
+			}
+		}
+		lines = append(lines, "")
+		lines = append(lines, removedSection...)
+		lines = append(lines, addedSection...)
+		lines = append(lines, renamedSection...)
+		lines = append(lines, modifiedSection...)
+		
+		// Add closing instruction
+		lines = append(lines, "---")
+		lines = append(lines, "")
+		lines = append(lines, "**Please analyze these changes and identify:**")
+		lines = append(lines, "1. Which changes are most likely to have introduced a regression")
+		lines = append(lines, "2. What functionality might be affected")
+		lines = append(lines, "3. Specific areas to investigate or test")
+		lines = append(lines, "")
+		
+		return strings.Join(lines, "\n")
+	}
+	
+	content := render()
+	
+	// Truncate the largest diffs first until the prompt fits the token budget
+	if maxTokens > 0 {
+		for estimateTokens(content) > maxTokens {
+			largest := -1
+			for i, body := range diffBodies {
+				if omittedLines[i] == 0 && len(body) > 0 && (largest == -1 || len(body) > len(diffBodies[largest])) {
+					largest = i
+				}
+			}
+			if largest == -1 {
+				fmt.Fprintf(e.out, "⚠️  Prompt still exceeds %d tokens (~%d) after truncating every diff.\n", maxTokens, estimateTokens(content))
+				break
+			}
+			omittedLines[largest] = len(diffBodies[largest])
+			content = render()
+		}
+	}
+	
+	content = appendPromptManifest(e.redactSecrets(content), diffData)
+	outputPath, err := e.writeArtifact(filepath.Join(snapshotDir, outputName), []byte(content))
+	if err == nil {
+		fmt.Fprintf(e.out, "✅ AI-ready prompt saved to %s\n", outputPath)
+	}
+	return outputPath, err
+}
+
+// Mask values matching the --redact patterns before a prompt leaves the machine
+func (e *engine) redactSecrets(content string) string {
+	if e.redactPatterns == nil {
+		return content
+	}
+	count := 0
+	for _, re := range e.redactPatterns {
+		content = re.ReplaceAllStringFunc(content, func(string) string {
+			count++
+			return "****"
+		})
+	}
+	if count > 0 {
+		fmt.Fprintf(e.out, "🔒 Redacted %d secret-like value%s from the prompt\n", count, plural(count))
+	}
+	return content
+}
+
+// Format the per-status subsections of a diff for the regression and multi-base prompts
+func formatStatusSections(diffData *DiffResult, withHunks bool) (removedSection, addedSection, modifiedSection, renamedSection []string) {
+	var removedFiles, addedFiles, modifiedFiles, renamedFiles []DiffFile
+	for _, file := range diffData.Files {
+		switch file.Status {
+		case "removed":
+			removedFiles = append(removedFiles, file)
+		case "added":
+			addedFiles = append(addedFiles, file)
+		case "modified":
+			modifiedFiles = append(modifiedFiles, file)
+		case "renamed":
+			renamedFiles = append(renamedFiles, file)
+			if file.Diff != "" {
+				modifiedFiles = append(modifiedFiles, file)
+			}
+		}
+	}
+	
+	if len(removedFiles) > 0 {
+		removedSection = append(removedSection, "### [REMOVED] Files")
+		removedSection = append(removedSection, "")
+		for _, file := range removedFiles {
+			removedSection = append(removedSection, fmt.Sprintf("- `%s`", file.File))
+		}
+		removedSection = append(removedSection, "")
+	}
+	
+	if len(addedFiles) > 0 {
+		addedSection = append(addedSection, "### [ADDED] Files")
+		addedSection = append(addedSection, "")
+		for _, file := range addedFiles {
+			addedSection = append(addedSection, fmt.Sprintf("- `%s`", file.File))
+		}
+		addedSection = append(addedSection, "")
+	}
+	
+	if len(renamedFiles) > 0 {
+		renamedSection = append(renamedSection, "### [RENAMED] Files")
+		renamedSection = append(renamedSection, "")
+		for _, file := range renamedFiles {
+			renamedSection = append(renamedSection, fmt.Sprintf("- `%s` → `%s`", file.RenamedFrom, file.File))
+		}
+		renamedSection = append(renamedSection, "")
+	}
+	
+	if len(modifiedFiles) > 0 && !withHunks {
+		modifiedSection = append(modifiedSection, "### [MODIFIED] Files")
+		modifiedSection = append(modifiedSection, "")
+		for _, file := range modifiedFiles {
+			counts := "size change only"
+			if file.Insertions != nil && file.Deletions != nil {
+				counts = fmt.Sprintf("+%d -%d", *file.Insertions, *file.Deletions)
+			}
+			modifiedSection = append(modifiedSection, fmt.Sprintf("- `%s` (%s)", file.File, counts))
+		}
+		modifiedSection = append(modifiedSection, "")
+	} else if len(modifiedFiles) > 0 {
+		modifiedSection = append(modifiedSection, "### [MODIFIED] Files")
+		modifiedSection = append(modifiedSection, "")
+		for _, file := range modifiedFiles {
+			if file.RenamedFrom != "" {
+				modifiedSection = append(modifiedSection, fmt.Sprintf("#### `%s` (renamed from `%s`)", file.File, file.RenamedFrom))
+			} else {
+				modifiedSection = append(modifiedSection, fmt.Sprintf("#### `%s`", file.File))
+			}
+			if file.LinesChanged != nil {
+				modifiedSection = append(modifiedSection, fmt.Sprintf("**Lines changed:** %d", *file.LinesChanged))
+			}
+			if file.SizeDelta != nil {
+				modifiedSection = append(modifiedSection, fmt.Sprintf("*%s; no line diff included.*", file.Message))
+			}
+			modifiedSection = append(modifiedSection, "")
+			
+			if file.Diff != "" {
+				modifiedSection = append(modifiedSection, "```diff")
+				modifiedSection = append(modifiedSection, cleanDiffLines(file.Diff)...)
+				modifiedSection = append(modifiedSection, "```")
+			}
+			modifiedSection = append(modifiedSection, "")
+		}
+	}
+	
+	return removedSection, addedSection, modifiedSection, renamedSection
+}
+
+// Format one titled diff section of a prompt
+func formatDiffSection(diffData *DiffResult, title, subtitle string, withHunks bool) []string {
+	var sectionLines []string
+	sectionLines = append(sectionLines, title)
+	sectionLines = append(sectionLines, "")
+	sectionLines = append(sectionLines, subtitle)
+	sectionLines = append(sectionLines, "")
+	if !withHunks {
+		sectionLines = append(sectionLines, "*Summary only: modified files are listed with line counts; ask for any file's full diff if you need it.*")
+		sectionLines = append(sectionLines, "")
+	}
+	
+	removedSection, addedSection, modifiedSection, renamedSection := formatStatusSections(diffData, withHunks)
+	sectionLines = append(sectionLines, removedSection...)
+	sectionLines = append(sectionLines, addedSection...)
+	sectionLines = append(sectionLines, renamedSection...)
+	sectionLines = append(sectionLines, modifiedSection...)
+	
+	return sectionLines
+}
+
+// Save one prompt with a section per base snapshot, each showing that snapshot's diff to the current code
+func (e *engine) saveMultiBasePrompt(diffs []*DiffResult, indices, names []string, snapshotDir string) (string, error) {
+	var lines []string
+	lines = append(lines, "# Code Analysis Request: Changes Since Several Checkpoints")
+	lines = append(lines, "")
+	lines = append(lines, fmt.Sprintf("I have %d snapshots of my code that were known to work, and my current code has a regression.", len(diffs)))
+	lines = append(lines, "Each section below shows everything that changed in the current working directory since one of them.")
+	lines = append(lines, "")
+	lines = append(lines, "**Context:**")
+	for i, index := range indices {
+		basePath := fmt.Sprintf("%s/%s_%s/", e.snapshotsDisplayPath(), index, names[i])
+		lines = append(lines, fmt.Sprintf("- `%s` \"%s\"", basePath, snapshotDisplayLabel(snapshotDir, index+"_"+names[i])))
+	}
+	lines = append(lines, "")
+	
+	for i, index := range indices {
+		label := snapshotDisplayLabel(snapshotDir, index+"_"+names[i])
+		lines = append(lines, formatDiffSection(diffs[i],
+			fmt.Sprintf("## Since Snapshot %s (\"%s\")", index, label),
+			fmt.Sprintf("**What changed between snapshot %s and the current code:**", index), true)...)
+		if len(diffs[i].Files) == 0 {
+			lines = append(lines, "*No changes since this snapshot.*")
+			lines = append(lines, "")
+		}
+	}
+	
+	lines = append(lines, "---")
+	lines = append(lines, "")
+	lines = append(lines, "**Please analyze these changes and identify:**")
+	lines = append(lines, "1. Which changes appear only after the later checkpoints, and so are the most likely cause")
+	lines = append(lines, "2. What functionality might be affected")
+	lines = append(lines, "3. Specific areas to investigate or test")
+	lines = append(lines, "")
+	
+	outputName := fmt.Sprintf("prompt_%s_analysis.md", strings.Join(indices, "_"))
+	content := appendPromptManifest(e.redactSecrets(strings.Join(lines, "\n")), diffs...)
+	outputPath, err := e.writeArtifact(filepath.Join(snapshotDir, outputName), []byte(content))
+	if err == nil {
+		fmt.Fprintf(e.out, "✅ AI-ready prompt saved to %s\n", outputPath)
+	}
+	return outputPath, err
+}
+
+// Save regression analysis prompt with two-part analysis
+// With summaryOnly, the cumulative section lists modified files with line counts instead of full hunks.
+func (e *engine) saveRegressionAnalysisPrompt(causalDiff, cumulativeDiff *DiffResult, baseIndex, baseName, nextIndex, nextName, snapshotDir, template string, summaryOnly bool) (string, error) {
+	basePath := fmt.Sprintf("%s/%s_%s/", e.snapshotsDisplayPath(), baseIndex, baseName)
+	nextPath := fmt.Sprintf("%s/%s_%s/", e.snapshotsDisplayPath(), nextIndex, nextName)
+	
+	// Section 1: The Immediate Breaking Change
+	section1 := formatDiffSection(causalDiff,
+		"## SECTION 1: The Immediate Breaking Change",
+		"**What changed between the last working version and the first broken version:**", true)
+	
+	// Section 2: The Full Picture
+	section2 := formatDiffSection(cumulativeDiff,
+		"## SECTION 2: The Full Picture (All Changes Since Working Version)",
+		"**What changed between the last working version and the current code:**", !summaryOnly)
+	
+	var content string
+	if template != "" {
+		// Status placeholders describe the causal diff; the cumulative diff is available as one block
+		removedSection, addedSection, modifiedSection, renamedSection := formatStatusSections(causalDiff, true)
+		content = applyPromptTemplate(template, map[string]string{
+			"BASE":       basePath,
+			"COMPARE":    nextPath,
+			"REMOVED":    strings.Join(removedSection, "\n"),
+			"ADDED":      strings.Join(addedSection, "\n"),
+			"MODIFIED":   strings.Join(modifiedSection, "\n"),
+			"RENAMED":    strings.Join(renamedSection, "\n"),
+			"CUMULATIVE": strings.Join(section2, "\n"),
+		})
+	} else {
+		var lines []string
+		lines = append(lines, "# AI Regression Analysis: Advanced Two-Part Investigation")
+		lines = append(lines, "")
+		lines = append(lines, "I have identified a regression in my code and need your help with a comprehensive analysis.")
+		lines = append(lines, "This prompt contains two parts that work together to identify the root cause and formulate a solution.")
+		lines = append(lines, "")
+		lines = append(lines, "**Context:**")
+		lines = append(lines, fmt.Sprintf("- **Last Known Good:** `%s` \"%s\" (working state)", basePath, snapshotDisplayLabel(snapshotDir, baseIndex+"_"+baseName)))
+		lines = append(lines, fmt.Sprintf("- **First Breaking Version:** `%s` \"%s\" (regression introduced)", nextPath, snapshotDisplayLabel(snapshotDir, nextIndex+"_"+nextName)))
+		lines = append(lines, "- **Current State:** Current working directory (may contain additional changes)")
+		if gitNote := snapshotGitNote(snapshotDir, baseIndex+"_"+baseName); gitNote != "" {
+			lines = append(lines, fmt.Sprintf("- The last known good snapshot was taken at %s", gitNote))
+		}
+		if gitNote := snapshotGitNote(snapshotDir, nextIndex+"_"+nextName); gitNote != "" {
+			lines = append(lines, fmt.Sprintf("- The first breaking snapshot was taken at %s", gitNote))
+		}
+		lines = append(lines, "")
+		lines = append(lines, "---")
+		lines = append(lines, "")
+		lines = append(lines, section1...)
+		
+		lines = append(lines, "---")
+		lines = append(lines, "")
+		lines = append(lines, section2...)
+		
+		lines = append(lines, "---")
+		lines = append(lines, "")
+		lines = append(lines, "## YOUR TASK:")
+		lines = append(lines, "")
+		lines = append(lines, "**Step 1:** Analyze SECTION 1 to identify the most likely root cause of the regression.")
+		lines = append(lines, "Focus on the specific changes that occurred between the working and broken states.")
+		lines = append(lines, "")
+		lines = append(lines, "**Step 2:** Using SECTION 2, formulate a solution that will work with the current codebase.")
+		lines = append(lines, "Consider all the additional changes that have been made since the regression was introduced.")
+		lines = append(lines, "")
+		lines = append(lines, "**Please provide:**")
+		lines = append(lines, "1. **Root Cause Analysis:** What specific change(s) in Section 1 likely caused the regression?")
+		lines = append(lines, "2. **Impact Assessment:** What functionality is affected and why?")
+		lines = append(lines, "3. **Solution Strategy:** How should this be fixed given the current state in Section 2?")
+		lines = append(lines, "4. **Implementation Plan:** Specific code changes or investigation steps needed.")
+		lines = append(lines, "")
+		content = strings.Join(lines, "\n")
+	}
+	
+	content = appendPromptManifest(e.redactSecrets(content), causalDiff, cumulativeDiff)
+	outputPath, err := e.writeArtifact(filepath.Join(snapshotDir, fmt.Sprintf("regression_analysis_%s.md", baseIndex)), []byte(content))
+	if err == nil {
+		fmt.Fprintf(e.out, "✅ Advanced regression analysis prompt saved to %s\n", outputPath)
+	}
+	return outputPath, err
+}
+
+// Join a relative path onto root, refusing results that escape root (e.g. "../" from a tampered snapshot)
+func containedPath(root, relPath string) (string, error) {
+	cleanRoot := filepath.Clean(root)
+	target := filepath.Clean(filepath.Join(cleanRoot, filepath.FromSlash(relPath)))
+	prefix := cleanRoot
+	if !strings.HasSuffix(prefix, string(filepath.Separator)) {
+		prefix += string(filepath.Separator)
+	}
+	if filepath.IsAbs(filepath.FromSlash(relPath)) || (target != cleanRoot && !strings.HasPrefix(target, prefix)) {
+		return "", fmt.Errorf("refusing path outside %s: %s", root, relPath)
+	}
+	return target, nil
+}
+
+// Print what restoring one file would change: current contents on the minus side, the snapshot's on the plus side
+func (e *engine) printRestoreDiff(snapFile, destFile, relPath string) {
+	snapContent, err := e.readSnapshotFile(snapFile)
+	if err != nil {
+		return
+	}
+	currContent, err := os.ReadFile(destFile)
+	if err != nil {
+		fmt.Fprintln(e.out, "  (new file)")
+		return
+	}
+	fmt.Fprintln(e.out, e.colorizeDiff(createUnifiedDiff(string(currContent), string(snapContent), relPath, DiffOptions{})))
+}
+
+// Restore snapshot with dry-run support
+// Files not in the snapshot are only deleted when deleteExtra is set (--clean); otherwise they are left alone.
+// With dryRun and showDiff, each file that would be overwritten is printed as a unified diff
+// from its current contents to the snapshot's. A non-nil preview implies dryRun: every file's
+// action is collected there instead of printed.
+func (e *engine) restoreSnapshot(snapshotPath, currentPath string, ignoreSet *IgnoreSet, dryRun, deleteExtra, showDiff bool, preview *[]RestoreAction) error {
+	if preview != nil {
+		dryRun, showDiff = true, false
+	}
+	if err := e.unlockSnapshot(snapshotPath); err != nil {
+		return err
+	}
+	snapshotFiles, snapIndex, err := e.listSnapshotFiles(snapshotPath, ignoreSet)
+	if err != nil {
+		return err
+	}
+	
+	// A tampered or hand-edited snapshot must not be able to write outside the target
+	emptyDirs := snapshotEmptyDirs(snapshotPath, ignoreSet)
+	for _, relPath := range append(append([]string{}, snapshotFiles...), emptyDirs...) {
+		if _, err := containedPath(currentPath, relPath); err != nil {
+			return err
+		}
+	}
+	
+	var restored, skipped int
+	progress := e.newProgress("Restoring", len(snapshotFiles))
+	resolve := snapshotFileResolver(snapshotPath, snapIndex)
+	
+	// Write files across the worker pool; each slot records whether that file was (or would be) restored.
+	// verified counts the written files whose re-read contents matched the snapshot.
+	written := make([]bool, len(snapshotFiles))
+	var verified atomic.Int64
+	err = e.forEachParallel(len(snapshotFiles), func(i int) error {
+		defer progress.Increment()
+		relPath := snapshotFiles[i]
+		snapFile := resolve(relPath)
+		destFile := filepath.Join(currentPath, relPath)
+		
+		// An unreadable snapshot file is reported at the end of the run (or fails it with --strict)
+		snapHash, err := e.hashFileIndexed(snapIndex, relPath, snapFile)
+		if err != nil {
+			return e.recordFileError(snapFile, err)
+		}
+		if _, err := os.Stat(destFile); err == nil {
+			if destHash, _ := e.hashFile(destFile); destHash == snapHash {
+				return nil
+			}
+		}
+		
+		if !dryRun {
+			if err := e.restoreFile(snapFile, destFile, snapHash); err != nil {
+				return fmt.Errorf("%s: %v", relPath, err)
+			}
+			verified.Add(1)
+		}
+		written[i] = true
+		return nil
+	})
+	progress.Clear()
+	
+	for i, relPath := range snapshotFiles {
+		if preview != nil {
+			action := "skip"
+			if written[i] {
+				action = "restore"
+			}
+			*preview = append(*preview, RestoreAction{Path: filepath.ToSlash(relPath), Action: action})
+		}
+		if !written[i] {
+			skipped++
+			continue
+		}
+		restored++
+		if preview != nil {
+			continue
+		}
+		if dryRun {
+			fmt.Fprintf(e.out, "Would restore: %s\n", relPath)
+			if showDiff {
+				e.printRestoreDiff(resolve(relPath), filepath.Join(currentPath, relPath), relPath)
+			}
+		} else {
+			fmt.Fprintf(e.out, "Restored: %s\n", relPath)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	
+	// Recreate empty directories, which the file list doesn't cover
+	for _, dir := range emptyDirs {
+		destDir := filepath.Join(currentPath, filepath.FromSlash(dir))
+		if _, err := os.Stat(destDir); err == nil {
+			continue
+		}
+		if preview != nil {
+			*preview = append(*preview, RestoreAction{Path: dir + "/", Action: "restore"})
+		} else if dryRun {
+			fmt.Fprintf(e.out, "Would create directory: %s\n", dir)
+		} else {
+			if err := os.MkdirAll(destDir, 0755); err != nil {
+				return err
+			}
+			fmt.Fprintf(e.out, "Created directory: %s\n", dir)
+		}
+	}
+	
+	// Merge-style restore: never touch files the snapshot doesn't have
+	if !deleteExtra {
+		if preview != nil {
+			return nil
+		}
+		fmt.Fprintln(e.out)
+		if dryRun {
+			fmt.Fprintf(e.out, "🧪 Dry run complete. %d file(s) would be restored, %d skipped. Files not in the snapshot are left untouched.\n", restored, skipped)
+		} else {
+			fmt.Fprintf(e.out, "♻️ Restore complete. %d file(s) restored to %s (%d verified), %d skipped. Files not in the snapshot were left untouched.\n", restored, currentPath, verified.Load(), skipped)
+		}
+		return nil
+	}
+	
+	// Delete files not in snapshot (only inside its subtree for a --path snapshot)
+	scope := snapshotScope(snapshotPath)
+	currentFiles, err := e.listFilesRecursively(filepath.Join(currentPath, filepath.FromSlash(scope)), currentPath, ignoreSet)
+	if err != nil {
+		return err
+	}
+	
+	snapshotFileSet := make(map[string]struct{})
+	for _, f := range snapshotFiles {
+		snapshotFileSet[f] = struct{}{}
+	}
+	
+	var deleted int
+	for _, relPath := range currentFiles {
+		if _, exists := snapshotFileSet[relPath]; !exists {
+			fullPath := filepath.Join(currentPath, relPath)
+			// --scan-secrets keeps such files out of snapshots, so their absence means nothing
+			if e.secretPatterns != nil && e.findSecret(fullPath) != "" {
+				continue
+			}
+			if preview != nil {
+				*preview = append(*preview, RestoreAction{Path: filepath.ToSlash(relPath), Action: "delete"})
+			} else if dryRun {
+				fmt.Fprintf(e.out, "Would delete: %s\n", relPath)
+			} else {
+				if err := os.Remove(fullPath); err == nil {
+					fmt.Fprintf(e.out, "Deleted: %s\n", relPath)
+				}
+			}
+			deleted++
+		}
+	}
+	
+	if preview != nil {
+		return nil
+	}
+	fmt.Fprintln(e.out)
+	if dryRun {
+		fmt.Fprintf(e.out, "🧪 Dry run complete. %d file(s) would be restored, %d skipped, %d would be deleted.\n", restored, skipped, deleted)
+	} else {
+		fmt.Fprintf(e.out, "♻️ Restore complete. %d file(s) restored (%d verified), %d skipped, %d deleted.\n", restored, verified.Load(), skipped, deleted)
+	}
+	
+	return nil
+}
+
+// Copy one snapshot file over the working tree, then re-read it to check it matches the snapshot's hash
+func (e *engine) restoreFile(snapFile, destFile, expectedHash string) error {
+	if err := os.MkdirAll(filepath.Dir(destFile), 0755); err != nil {
+		return err
+	}
+	
+	src, err := e.openSnapshotFile(snapFile)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	
+	dst, err := os.Create(destFile)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	if info, err := os.Stat(snapFile); err == nil {
+		os.Chmod(destFile, info.Mode().Perm())
+	}
+	
+	writtenHash, err := e.hashFile(destFile)
+	if err != nil {
+		return err
+	}
+	if writtenHash != expectedHash {
+		return fmt.Errorf("verification failed: wrote %s, snapshot has %s", writtenHash, expectedHash)
+	}
+	return nil
+}
+
+// Write a snapshot's files into an empty directory (no comparisons, nothing deleted); returns the file count
+func (e *engine) extractSnapshot(snapshotPath, dest string, ignoreSet *IgnoreSet) (int, error) {
+	if err := e.unlockSnapshot(snapshotPath); err != nil {
+		return 0, err
+	}
+	snapshotFiles, snapIndex, err := e.listSnapshotFiles(snapshotPath, ignoreSet)
+	if err != nil {
+		return 0, err
+	}
+	resolve := snapshotFileResolver(snapshotPath, snapIndex)
+	err = e.forEachParallel(len(snapshotFiles), func(i int) error {
+		relPath := snapshotFiles[i]
+		destFile, err := containedPath(dest, relPath)
+		if err != nil {
+			return err
+		}
+		snapFile := resolve(relPath)
+		snapHash, err := e.hashFileIndexed(snapIndex, relPath, snapFile)
+		if err != nil {
+			return fmt.Errorf("%s: %v", relPath, err)
+		}
+		if err := e.restoreFile(snapFile, destFile, snapHash); err != nil {
+			return fmt.Errorf("%s: %v", relPath, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, dir := range snapshotEmptyDirs(snapshotPath, ignoreSet) {
+		if destDir, err := containedPath(dest, dir); err == nil {
+			os.MkdirAll(destDir, 0755)
+		}
+	}
+	return len(snapshotFiles), nil
+}
+
+// Narrow a known-good/known-bad pair down to adjacent snapshots by testing midpoints.
+// Returns the last good and first bad snapshot.
+func bisectSnapshots(indices []int, good, bad int, isGood func(index int) (bool, error)) (int, int, error) {
+	for {
+		var between []int
+		for _, index := range indices {
+			if index > good && index < bad {
+				between = append(between, index)
+			}
+		}
+		if len(between) == 0 {
+			return good, bad, nil
+		}
+		mid := between[(len(between)-1)/2]
+		ok, err := isGood(mid)
+		if err != nil {
+			return good, bad, err
+		}
+		if ok {
+			good = mid
+		} else {
+			bad = mid
+		}
+	}
+}
+
+// Helper function to check if slice contains string
+func contains(slice []string, item string) bool {
+	for _, s := range slice {
+		if s == item {
+			return true
+		}
+	}
+	return false
+}
+
+// Copy directory recursively; files in reuse (unchanged since the parent snapshot) are indexed, not copied
+func (e *engine) copyDir(src, dest string, ignoreSet *IgnoreSet, baseSrc string, reuse map[string]FileIndexEntry, progress *progressReporter) (CopyStats, error) {
+	stats := CopyStats{Index: make(map[string]FileIndexEntry)}
+	if baseSrc == "" {
+		baseSrc = src
+	}
+	
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		// An unreadable subdirectory is skipped; an unreadable project root is fatal
+		if src == baseSrc {
+			return stats, err
+		}
+		return stats, e.recordFileError(src, err)
+	}
+	
+	// Files are copied after the walk so they can share the worker pool
+	var filePaths, fileRelPaths []string
+	for _, entry := range entries {
+		srcPath := filepath.Join(src, entry.Name())
+		relPath, err := filepath.Rel(baseSrc, srcPath)
+		if err != nil {
+			continue
+		}
+		
+		// Directory and file links are only copied through with --follow-symlinks
+		isDir := entry.IsDir()
+		if symlinkedDir(srcPath, entry.Type()) {
+			if !e.followSymlinks {
+				continue
+			}
+			if symlinkLoops(baseSrc, srcPath) {
+				e.warnOnce(fmt.Sprintf("⚠️  Not following %s: the link loops back into its own parent", filepath.ToSlash(relPath)))
+				continue
+			}
+			isDir = true
+		} else if !e.followSymlinks && symlinkedFile(srcPath, entry.Type()) {
+			continue
+		}
+		
+		// Explicitly skip the snapshots directory, and any nested project's
+		if e.isSnapshotsDir(srcPath, baseSrc) || e.isNestedSnapshotsDir(relPath, isDir) {
+			continue
+		}
+		
+		if isIgnored(relPath, ignoreSet) || e.beyondMaxDepth(relPath, isDir) {
+			continue
+		}
+		
+		if entry.Name() == ".snapshotignore" && src != baseSrc {
+			e.warnNestedProject(filepath.Dir(relPath), "its .snapshotignore is not applied (only the root one is)")
+		}
+		
+		destPath := filepath.Join(dest, entry.Name())
+		
+		if isDir {
+			err := os.MkdirAll(destPath, 0755)
+			if err != nil {
+				return stats, err
+			}
+			subStats, err := e.copyDir(srcPath, destPath, ignoreSet, baseSrc, reuse, progress)
+			stats.Files += subStats.Files
+			stats.Bytes += subStats.Bytes
+			stats.Skipped += subStats.Skipped
+			stats.Secrets = append(stats.Secrets, subStats.Secrets...)
+			stats.Referenced += subStats.Referenced
+			for path, indexEntry := range subStats.Index {
+				stats.Index[path] = indexEntry
+			}
+			stats.EmptyDirs = append(stats.EmptyDirs, subStats.EmptyDirs...)
+			// Remember directories that end up empty so restore can recreate them
+			// (but not ignored ones that were only entered for an ALWAYS SNAPSHOT glob)
+			if subStats.Files == 0 && subStats.Referenced == 0 && len(subStats.EmptyDirs) == 0 && !matchesExclusion(relPath, ignoreSet) {
+				stats.EmptyDirs = append(stats.EmptyDirs, filepath.ToSlash(relPath))
+			}
+			if err != nil {
+				return stats, err
+			}
+		} else if reused, ok := reuse[filepath.ToSlash(relPath)]; ok {
+			stats.Referenced++
+			stats.Index[filepath.ToSlash(relPath)] = reused
+			progress.Increment()
+		} else {
+			filePaths = append(filePaths, srcPath)
+			fileRelPaths = append(fileRelPaths, relPath)
+		}
+	}
+	
+	err = e.forEachParallel(len(filePaths), func(i int) error {
+		return e.copySnapshotFile(filePaths[i], filepath.Join(dest, filepath.Base(filePaths[i])), fileRelPaths[i], &stats, progress)
+	})
+	return stats, err
+}
+
+// Copy one file into a snapshot, recording it in stats; only fatal errors are returned
+func (e *engine) copySnapshotFile(srcPath, destPath, relPath string, stats *CopyStats, progress *progressReporter) error {
+	// Stat, not Lstat: a followed file link is stored with its target's contents, size, mode and mtime
+	info, statErr := os.Stat(srcPath)
+	if statErr == nil && e.exceedsMaxFileSize(info.Size()) {
+		e.copyStatsMu.Lock()
+		stats.Skipped++
+		e.copyStatsMu.Unlock()
+		if e.verbose {
+			fmt.Fprintf(e.out, "   ⏭️  Skipping %s (%s exceeds --max-file-size)\n", filepath.ToSlash(relPath), formatBytes(info.Size()))
+		}
+		return nil
+	}
+	if e.secretPatterns != nil && statErr == nil && info.Mode().IsRegular() {
+		if pattern := e.findSecret(srcPath); pattern != "" {
+			e.copyStatsMu.Lock()
+			stats.Secrets = append(stats.Secrets, fmt.Sprintf("%s (matches %s)", filepath.ToSlash(relPath), pattern))
+			e.copyStatsMu.Unlock()
+			progress.Increment()
+			return nil
+		}
+	}
+	
+	err := os.MkdirAll(filepath.Dir(destPath), 0755)
+	if err != nil {
+		return err
+	}
+	
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return e.recordFileError(srcPath, err)
+	}
+	defer src.Close()
+	
+	dst, err := os.Create(destPath)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+	
+	// Hash while copying so the file index costs no extra read
+	hasher := sha1.New()
+	var written int64
+	if e.encryptionKey != nil {
+		written, err = e.copySealed(dst, io.TeeReader(src, hasher))
+	} else {
+		written, err = io.Copy(io.MultiWriter(dst, hasher), src)
+	}
+	if err != nil {
+		// Don't leave a truncated copy behind
+		dst.Close()
+		os.Remove(destPath)
+		return e.recordFileError(srcPath, err)
+	}
+	e.copyStatsMu.Lock()
+	stats.Files++
+	stats.Bytes += written
+	stats.Index[filepath.ToSlash(relPath)] = FileIndexEntry{Size: written, Hash: hex.EncodeToString(hasher.Sum(nil))}
+	e.copyStatsMu.Unlock()
+	progress.Increment()
+	
+	// Preserve the modification time so --trust-mtime can match unchanged files, and the
+	// permission bits so executables come back (and export) as executables
+	dst.Close()
+	if statErr == nil {
+		os.Chtimes(destPath, info.ModTime(), info.ModTime())
+		os.Chmod(destPath, info.Mode().Perm())
+	}
+	return nil
+}
+
+// Stream src to dst sealed with encryptionKey; returns the plaintext size
+func (e *engine) copySealed(dst io.Writer, src io.Reader) (int64, error) {
+	w, err := newSealedWriter(dst, e.encryptionKey, e.encryptionSalt)
+	if err != nil {
+		return 0, err
+	}
+	written, err := io.Copy(w, src)
+	if err != nil {
+		return written, err
+	}
+	return written, w.Close()
+}
+
+// Compile the built-in secret patterns plus any extra ones from .snapshotrc
+func compileSecretPatterns(extra []string) ([]*regexp.Regexp, error) {
+	var patterns []*regexp.Regexp
+	for _, pattern := range append(append([]string{}, defaultSecretPatterns...), extra...) {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid secret pattern %q: %v", pattern, err)
+		}
+		patterns = append(patterns, re)
+	}
+	return patterns, nil
+}
+
+// Return the first secret pattern a text file matches, or "" (binary and unreadable files aren't scanned).
+// Only the first SECRET_SCAN_LIMIT bytes are read, so a large file never has to fit in memory.
+func (e *engine) findSecret(path string) string {
+	file, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	content, err := io.ReadAll(io.LimitReader(file, SECRET_SCAN_LIMIT))
+	if err != nil || bytes.IndexByte(content, 0) >= 0 {
+		return ""
+	}
+	for _, re := range e.secretPatterns {
+		if re.Match(content) {
+			return re.String()
+		}
+	}
+	return ""
+}
+
+// Copy an explicit list of project files (e.g. from --git-changed) into a snapshot
+func (e *engine) copyFiles(projectRoot, dest string, files []string, progress *progressReporter) (CopyStats, error) {
+	stats := CopyStats{Index: make(map[string]FileIndexEntry)}
+	for _, relPath := range files {
+		if _, err := containedPath(dest, relPath); err != nil {
+			return stats, err
+		}
+	}
+	err := e.forEachParallel(len(files), func(i int) error {
+		return e.copySnapshotFile(filepath.Join(projectRoot, files[i]), filepath.Join(dest, files[i]), files[i], &stats, progress)
+	})
+	return stats, err
+}
+
+// List files git reports as modified, staged or untracked, filtered by the ignore rules
+func (e *engine) gitChangedFiles(projectRoot string, ignoreSet *IgnoreSet) ([]string, error) {
+	cmd := exec.Command("git", "status", "--porcelain", "-z", "--untracked-files=all")
+	cmd.Dir = projectRoot
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git status failed (is this a git repository?): %v", err)
+	}
+	
+	// Porcelain paths are relative to the repository root, not the working directory
+	topCmd := exec.Command("git", "rev-parse", "--show-toplevel")
+	topCmd.Dir = projectRoot
+	topOutput, err := topCmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git rev-parse failed: %v", err)
+	}
+	repoRoot := strings.TrimSpace(string(topOutput))
+	
+	var files []string
+	records := strings.Split(string(output), "\x00")
+	for i := 0; i < len(records); i++ {
+		record := records[i]
+		if len(record) < 4 {
+			continue
+		}
+		status, path := record[:2], record[3:]
+		// Renames and copies are followed by the original path, which we don't need
+		if status[0] == 'R' || status[0] == 'C' {
+			i++
+		}
+		// Deleted files have nothing to copy
+		if status[0] == 'D' || status[1] == 'D' {
+			continue
+		}
+		
+		relPath, err := filepath.Rel(projectRoot, filepath.Join(repoRoot, filepath.FromSlash(path)))
+		if err != nil || strings.HasPrefix(relPath, "..") {
+			continue
+		}
+		if isIgnored(relPath, ignoreSet) || e.isSnapshotsDir(filepath.Join(projectRoot, relPath), projectRoot) {
+			continue
+		}
+		// git tracks links themselves, so a changed link is skipped here too unless --follow-symlinks
+		fullPath := filepath.Join(projectRoot, relPath)
+		if info, err := os.Lstat(fullPath); err != nil || (!e.followSymlinks && symlinkedFile(fullPath, info.Mode())) {
+			continue
+		}
+		if info, err := os.Stat(fullPath); err != nil || info.IsDir() {
+			continue
+		}
+		files = append(files, relPath)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Check whether a path lies below the --max-depth limit; a directory counts
+// as too deep when the files inside it would be
+func (e *engine) beyondMaxDepth(relPath string, isDir bool) bool {
+	if e.maxDepth < 0 {
+		return false
+	}
+	depth := strings.Count(filepath.ToSlash(relPath), "/")
+	if isDir {
+		depth++
+	}
+	return depth > e.maxDepth
+}
+
+// Check whether a file of this size is over the --max-file-size limit
+func (e *engine) exceedsMaxFileSize(size int64) bool {
+	return e.maxFileSize > 0 && size > e.maxFileSize
+}
+
+// Parse a human-readable size such as "10MB", "512 KB", "1.5GB" or "2048" (bytes)
+func parseByteSize(value string) (int64, error) {
+	trimmed := strings.ToUpper(strings.TrimSpace(value))
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		size   int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(trimmed, unit.suffix) {
+			trimmed = strings.TrimSpace(strings.TrimSuffix(trimmed, unit.suffix))
+			multiplier = unit.size
+			break
+		}
+	}
+	number, err := strconv.ParseFloat(trimmed, 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("invalid size %q (expected e.g. 500KB, 10MB, 1GB)", value)
+	}
+	return int64(number * float64(multiplier)), nil
+}
+
+// Format a byte count for humans, e.g. "12.4 MB"
+func formatBytes(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	value := float64(bytes) / unit
+	for _, suffix := range []string{"KB", "MB", "GB"} {
+		if value < unit || suffix == "GB" {
+			return fmt.Sprintf("%.1f %s", value, suffix)
+		}
+		value /= unit
+	}
+	return fmt.Sprintf("%d B", bytes)
+}
+
+// Flags is a parsed command line; go/snapshot.go fills it from the program arguments and Run
+// carries it out. Pointer fields are nil when the flag wasn't given, so .snapshotrc applies.
+type Flags struct {
+	Args         []string // snapshot references, label words and subcommands, in order
+	EscapedLabel []string // everything after "--", always used as the label
+	
+	Help, Diff, Prompt, Restore, AnalyzeRegression, Range, List bool
+	
+	// Snapshot creation
+	DryRun, DevMode, Force, AllowEmpty, GitChanged, StdinLabel, Incremental bool
+	Encrypt, ScanSecrets                                                    bool
+	ForceIndex                                                              int    // 0 = the next free index
+	Path                                                                    string // --path subtree
+	
+	// Diffs, prompts and restores
+	DiffOptions                                                DiffOptions // --ignore-whitespace, --ignore-eol, --trust-mtime, --compare-manifest
+	HTML, Stat, Patch, NameOnly, NameStatus, JSONLines, NoSave bool
+	Open, SummaryOnly, Redact, Clean, ShowDiff, PreviewJSON    bool
+	OnlyStatus                                                 []string // --only-status values as given, each a comma list
+	MaxDiffSize, Against, Into, Template                       string
+	MaxTokens                                                  int
+	
+	// log, prune and --list; age windows such as "30d" are checked by Run
+	Oneline                  bool
+	LogLimit, Keep           int
+	OlderThan, Since, Before string
+	
+	// Settings shared by every command
+	NoInteractive, Quiet, Strict, Verbose, ExactCase        bool
+	NoGitignore, FollowSymlinks, MarkdownLog, GzipArtifacts bool
+	SnapshotsDir, MaxFileSize                               string
+	Concurrency                                             int  // 0 = .snapshotrc or GOMAXPROCS
+	ManifestLimit                                           *int // nil = .snapshotrc or DEFAULT_MANIFEST_LIMIT
+	MaxDepth                                                *int // nil = no limit
+}
+
+// Run carries out one command line for the project at projectRoot and returns the exit code.
+// Prompts read from stdin; results go to stdout and errors to stderr.
+func Run(projectRoot string, flags Flags, stdin io.Reader, stdout, stderr io.Writer) int {
+	return newEngine(stdin, stdout, stderr).run(projectRoot, flags)
+}
+
+// Usage writes the command-line help
+func Usage(w io.Writer) {
+	newEngine(nil, w, nil).showHelp()
+}
+
+func (e *engine) run(projectRoot string, flags Flags) int {
+	if absRoot, err := filepath.Abs(projectRoot); err == nil {
+		e.projectRoot = absRoot
+	}
+	hasHelp, hasDiff, hasPrompt, hasRestore, hasAnalyzeRegression := flags.Help, flags.Diff, flags.Prompt, flags.Restore, flags.AnalyzeRegression
+	isDryRun, isDevMode, hasHTML, hasStat, hasRange := flags.DryRun, flags.DevMode, flags.HTML, flags.Stat, flags.Range
+	noInteractive, isForce, allowEmpty, hasList := flags.NoInteractive, flags.Force, flags.AllowEmpty, flags.List
+	labelArgs := flags.Args
+	escapedLabel := flags.EscapedLabel
+	maxTokens := flags.MaxTokens
+	diffOpts := flags.DiffOptions
+	templatePath := flags.Template
+	maxFileSizeFlag := flags.MaxFileSize
+	forceIndex := flags.ForceIndex
+	scanSecrets, encrypt, redact := flags.ScanSecrets, flags.Encrypt, flags.Redact
+	maxDiffSizeFlag := flags.MaxDiffSize
+	intoDir, scopeDir, againstDir := flags.Into, flags.Path, flags.Against
+	isOneline, isGitChanged, stdinLabel, isIncremental := flags.Oneline, flags.GitChanged, flags.StdinLabel, flags.Incremental
+	hasNameOnly, hasNameStatus, noSave, jsonLines := flags.NameOnly, flags.NameStatus, flags.NoSave, flags.JSONLines
+	openAfter, showDiff, previewJSON, hasPatch := flags.Open, flags.ShowDiff, flags.PreviewJSON, flags.Patch
+	logLimit, isClean, keepCount, summaryOnly := flags.LogLimit, flags.Clean, flags.Keep, flags.SummaryOnly
+	e.noGitignore, e.followSymlinks, e.exactCase = flags.NoGitignore, flags.FollowSymlinks, flags.ExactCase
+	e.quietMode, e.strictMode, e.verbose = flags.Quiet, flags.Strict, flags.Verbose
+	e.markdownLog, e.gzipArtifacts = flags.MarkdownLog, flags.GzipArtifacts
+	if flags.MaxDepth != nil {
+		e.maxDepth = *flags.MaxDepth
+	}
+	
+	var olderThan, sinceWindow, beforeWindow time.Duration
+	for _, window := range []struct {
+		flag  string
+		value string
+		dest  *time.Duration
+	}{{"--older-than", flags.OlderThan, &olderThan}, {"--since", flags.Since, &sinceWindow}, {"--before", flags.Before, &beforeWindow}} {
+		if window.value == "" {
+			continue
+		}
+		parsed, err := parseAgeDuration(window.value)
+		if err != nil {
+			fmt.Fprintf(e.errOut, "❌ Invalid %s: %v\n", window.flag, err)
+			return 1
+		}
+		*window.dest = parsed
+	}
+	onlyStatus := make(map[string]bool)
+	for _, value := range flags.OnlyStatus {
+		if err := parseStatusFilter(value, onlyStatus); err != nil {
+			fmt.Fprintf(e.errOut, "❌ Invalid --only-status: %v\n", err)
+			return 1
+		}
+	}
+	
+	// Path lists and JSON Lines are meant for scripts, so they get no decoration
+	nameMode := hasDiff && (hasNameOnly || hasNameStatus || jsonLines)
+	if !nameMode && !previewJSON {
+		fmt.Fprintln(e.out, "")
+	}
+	
+	// Report any files skipped because they could not be read
+	defer e.printFileErrorSummary()
+	
+	// Handle init command
+	if len(labelArgs) > 0 && labelArgs[0] == "init" {
+		if err := e.initializeProject(projectRoot); err != nil {
+			fmt.Fprintf(e.errOut, "❌ Initialization failed: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+	
+	// Show help if requested or if no arguments provided
+	if hasHelp {
+		e.showHelp()
+		return 0
+	}
+	
+	// Merge .snapshotrc defaults underneath the command-line flags
+	config, err := loadConfig(projectRoot)
+	if err != nil {
+		fmt.Fprintf(e.errOut, "❌ %v\n", err)
+		return 1
+	}
+	isDevMode = isDevMode || config.DevMode
+	diffOpts.IgnoreWhitespace = diffOpts.IgnoreWhitespace || config.IgnoreWhitespace
+	diffOpts.IgnoreEOL = diffOpts.IgnoreEOL || config.IgnoreEOL
+	diffOpts.TrustMtime = diffOpts.TrustMtime || config.TrustMtime
+	noInteractive = noInteractive || config.NoInteractive
+	if maxTokens == 0 {
+		maxTokens = config.MaxTokens
+	}
+	if templatePath == "" {
+		templatePath = config.Template
+	}
+	if maxFileSizeFlag == "" {
+		maxFileSizeFlag = config.MaxFileSize
+	}
+	if maxDiffSizeFlag == "" {
+		maxDiffSizeFlag = config.MaxDiffSize
+	}
+	if maxDiffSizeFlag != "" {
+		diffOpts.MaxDiffSize, err = parseByteSize(maxDiffSizeFlag)
+		if err != nil {
+			fmt.Fprintf(e.errOut, "❌ Invalid --max-diff-size: %v\n", err)
+			return 1
+		}
+	}
+	e.concurrency = config.Concurrency
+	if flags.Concurrency > 0 {
+		e.concurrency = flags.Concurrency
+	}
+	if config.ManifestLimit != nil {
+		e.manifestLimit = *config.ManifestLimit
+	}
+	e.markdownLog = e.markdownLog || config.MarkdownLog
+	e.gzipArtifacts = e.gzipArtifacts || config.GzipArtifacts
+	if scanSecrets || config.ScanSecrets || redact || config.Redact {
+		patterns, err := compileSecretPatterns(config.SecretPatterns)
+		if err != nil {
+			fmt.Fprintf(e.errOut, "❌ Invalid secret_patterns in .snapshotrc: %v\n", err)
+			return 1
+		}
+		if scanSecrets || config.ScanSecrets {
+			e.secretPatterns = patterns
+		}
+		if redact || config.Redact {
+			e.redactPatterns = patterns
+		}
+	}
+	if flags.ManifestLimit != nil {
+		e.manifestLimit = *flags.ManifestLimit
+	}
+	if maxFileSizeFlag != "" {
+		e.maxFileSize, err = parseByteSize(maxFileSizeFlag)
+		if err != nil {
+			fmt.Fprintf(e.errOut, "❌ Invalid --max-file-size: %v\n", err)
+			return 1
+		}
+	}
+	
+	// Resolve the snapshots directory: --snapshots-dir wins over SNAPSHOT_DIR, then .snapshotrc
+	if config.SnapshotsDir != "" {
+		e.snapshotsDirName = config.SnapshotsDir
+	}
+	if envDir := os.Getenv("SNAPSHOT_DIR"); envDir != "" {
+		e.snapshotsDirName = envDir
+	}
+	if flags.SnapshotsDir != "" {
+		e.snapshotsDirName = flags.SnapshotsDir
+	}
+	
+	// Check if project is initialized (except for help and init commands)
+	snapshotignorePath := filepath.Join(projectRoot, ".snapshotignore")
+	if _, err := os.Stat(snapshotignorePath); os.IsNotExist(err) {
+		fmt.Fprintln(e.out, "")
+		fmt.Fprintln(e.out, "🚨 Welcome to jw-ai-snapshot!")
+		fmt.Fprintln(e.out, "   It looks like this project hasn't been initialized.")
+		fmt.Fprintln(e.out, "")
+		fmt.Fprintln(e.out, "   Please run: ./snapshot_v2 init")
+		fmt.Fprintln(e.out, "")
+		return 1
+	}
+	
+	// A --dry-run writes nothing, not even the snapshots directory
+	snapshotsRoot := e.resolveSnapshotsRoot(projectRoot)
+	if !isDryRun {
+		if err := os.MkdirAll(snapshotsRoot, 0755); err != nil {
+			fmt.Fprintf(e.errOut, "❌ Failed to create snapshots directory: %s. Please check permissions.\n", snapshotsRoot)
+			return 1
+		}
+	}
+	
+	// Offer an interactive picker when no index was given on a terminal
+	if (hasDiff || hasPrompt || hasRestore) && len(labelArgs) == 0 && !noInteractive && e.interactive {
+		selected, err := e.selectSnapshotInteractively(snapshotsRoot)
+		if err != nil {
+			fmt.Fprintf(e.errOut, "❌ Snapshot selection failed: %v\n", err)
+			return 1
+		}
+		labelArgs = []string{strconv.Itoa(selected)}
+	}
+	
+	if (hasDiff || hasPrompt || hasRestore || hasAnalyzeRegression) && len(labelArgs) == 0 {
+		fmt.Fprintf(e.errOut, "❌ Please specify a snapshot index for --diff/--prompt/--restore/--analyze-regression\n")
+		return 1
+	}
+	
+	// Load ignoreSet once here based on projectRoot
+	mainIgnoreSet := e.loadIgnoreList(projectRoot, isDevMode)
+	
+	// Load a custom prompt template if one is configured
+	promptTemplate := ""
+	if hasPrompt || hasAnalyzeRegression {
+		promptTemplate, err = loadPromptTemplate(projectRoot, templatePath)
+		if err != nil {
+			fmt.Fprintf(e.errOut, "❌ Failed to read prompt template: %v\n", err)
+			return 1
+		}
+	}
+	
+	// Handle rename command
+	if len(labelArgs) > 0 && labelArgs[0] == "rename" {
+		if len(labelArgs) < 3 {
+			fmt.Fprintf(e.errOut, "❌ Usage: ./snapshot_v2 rename NNNN \"new label\"\n")
+			return 1
+		}
+		renameIndex, err := e.resolveSnapshot(snapshotsRoot, labelArgs[1])
+		if err != nil {
+			fmt.Fprintf(e.errOut, "❌ Invalid snapshot reference: %v\n", err)
+			return 1
+		}
+		if err := e.renameSnapshot(snapshotsRoot, renameIndex, strings.Join(labelArgs[2:], " ")); err != nil {
+			fmt.Fprintf(e.errOut, "❌ Rename failed: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+	
+	// Handle show command (single-file diff against the working directory)
+	if len(labelArgs) > 0 && labelArgs[0] == "show" {
+		if len(labelArgs) < 3 {
+			fmt.Fprintf(e.errOut, "❌ Usage: ./snapshot_v2 show NNNN <path>\n")
+			return 1
+		}
+		showIndex, err := e.resolveSnapshot(snapshotsRoot, labelArgs[1])
+		if err != nil {
+			fmt.Fprintf(e.errOut, "❌ Invalid snapshot reference: %v\n", err)
+			return 1
+		}
+		showFolder := findSnapshotByIndex(snapshotsRoot, showIndex)
+		if showFolder == "" {
+			fmt.Fprintf(e.errOut, "❌ Snapshot %s not found\n", padNumber(showIndex, SNAPSHOT_INDEX_WIDTH))
+			return 1
+		}
+		if err := e.showFileDiff(filepath.Join(snapshotsRoot, showFolder), projectRoot, filepath.ToSlash(filepath.Clean(labelArgs[2])), diffOpts); err != nil {
+			fmt.Fprintf(e.errOut, "❌ %v\n", err)
+			return 1
+		}
+		return 0
+	}
+	
+	// Handle export command
+	if len(labelArgs) > 0 && labelArgs[0] == "export" {
+		if len(labelArgs) < 3 {
+			fmt.Fprintf(e.errOut, "❌ Usage: ./snapshot_v2 export NNNN <file.tar.gz>\n")
+			return 1
+		}
+		exportIndex, err := e.resolveSnapshot(snapshotsRoot, labelArgs[1])
+		if err != nil {
+			fmt.Fprintf(e.errOut, "❌ Invalid snapshot reference: %v\n", err)
+			return 1
+		}
+		exportFolder := findSnapshotByIndex(snapshotsRoot, exportIndex)
+		if exportFolder == "" {
+			fmt.Fprintf(e.errOut, "❌ Snapshot %s not found\n", padNumber(exportIndex, SNAPSHOT_INDEX_WIDTH))
+			return 1
+		}
+		count, err := e.exportSnapshot(filepath.Join(snapshotsRoot, exportFolder), labelArgs[2])
+		if err != nil {
+			fmt.Fprintf(e.errOut, "❌ Export failed: %v\n", err)
+			return 1
+		}
+		fmt.Fprintf(e.out, "📦 Exported %s (%d file(s)) to %s\n", exportFolder, count, labelArgs[2])
+		return 0
+	}
+	
+	// Handle import command
+	if len(labelArgs) > 0 && labelArgs[0] == "import" {
+		if len(labelArgs) < 2 {
+			fmt.Fprintf(e.errOut, "❌ Usage: ./snapshot_v2 import <file.tar.gz>\n")
+			return 1
+		}
+		if err := os.MkdirAll(snapshotsRoot, 0755); err != nil {
+			fmt.Fprintf(e.errOut, "❌ Failed to create %s: %v\n", snapshotsRoot, err)
+			return 1
+		}
+		importIndex := getNextSnapshotIndex(snapshotsRoot)
+		prefix := padNumber(importIndex, SNAPSHOT_INDEX_WIDTH)
+		tempDir := filepath.Join(snapshotsRoot, ".tmp-"+prefix)
+		os.RemoveAll(tempDir)
+		
+		count, err := extractSnapshotArchive(labelArgs[1], tempDir)
+		if err != nil {
+			os.RemoveAll(tempDir)
+			fmt.Fprintf(e.errOut, "❌ Import failed: %v\n", err)
+			return 1
+		}
+		
+		// Keep the original label when the archive carries metadata
+		importLabel := strings.TrimSuffix(strings.TrimSuffix(filepath.Base(labelArgs[1]), ".tgz"), ".tar.gz")
+		if meta, ok := loadSnapshotMeta(tempDir); ok && meta.Label != "" {
+			importLabel = meta.Label
+		}
+		importFolder := prefix + "_" + sanitizeLabel(importLabel)
+		if err := os.Rename(tempDir, filepath.Join(snapshotsRoot, importFolder)); err != nil {
+			os.RemoveAll(tempDir)
+			fmt.Fprintf(e.errOut, "❌ Failed to finalize snapshot directory: %v\n", err)
+			return 1
+		}
+		if err := appendManifestEvent(snapshotsRoot, prefix, fmt.Sprintf("[IMPORTED] \"%s\" from %s", importLabel, filepath.Base(labelArgs[1]))); err != nil {
+			fmt.Fprintf(e.errOut, "⚠️  Failed to update change manifest: %v\n", err)
+		}
+		fmt.Fprintf(e.out, "📥 Imported %d file(s) as %s\n", count, importFolder)
+		return 0
+	}
+	
+	// Handle history command
+	if len(labelArgs) > 0 && labelArgs[0] == "history" {
+		if len(labelArgs) < 2 {
+			fmt.Fprintf(e.errOut, "❌ Usage: ./snapshot_v2 history <path>\n")
+			return 1
+		}
+		relPath := filepath.ToSlash(filepath.Clean(labelArgs[1]))
+		if len(listSnapshotIndices(snapshotsRoot)) == 0 {
+			fmt.Fprintln(e.out, "📭 No snapshots found.")
+			return 0
+		}
+		history, err := e.fileHistory(snapshotsRoot, projectRoot, relPath)
+		if err != nil {
+			fmt.Fprintf(e.errOut, "❌ %v\n", err)
+			return 1
+		}
+		fmt.Fprintf(e.out, "📜 History of %s:\n", relPath)
+		for _, line := range history {
+			fmt.Fprintln(e.out, line)
+		}
+		return 0
+	}
+	
+	// Handle bisect command: extract midpoints to a temp directory and ask whether each is good
+	if len(labelArgs) > 0 && labelArgs[0] == "bisect" {
+		if len(labelArgs) < 3 {
+			fmt.Fprintf(e.errOut, "❌ Usage: ./snapshot_v2 bisect GOOD BAD\n")
+			return 1
+		}
+		good, err := e.resolveSnapshot(snapshotsRoot, labelArgs[1])
+		if err != nil {
+			fmt.Fprintf(e.errOut, "❌ Invalid snapshot reference: %v\n", err)
+			return 1
+		}
+		bad, err := e.resolveSnapshot(snapshotsRoot, labelArgs[2])
+		if err != nil {
+			fmt.Fprintf(e.errOut, "❌ Invalid snapshot reference: %v\n", err)
+			return 1
+		}
+		if bad <= good {
+			fmt.Fprintf(e.errOut, "❌ The bad snapshot must come after the good one\n")
+			return 1
+		}
+		for _, index := range []int{good, bad} {
+			if findSnapshotByIndex(snapshotsRoot, index) == "" {
+				fmt.Fprintf(e.errOut, "❌ Snapshot folder not found for index %s\n", padNumber(index, SNAPSHOT_INDEX_WIDTH))
+				return 1
+			}
+		}
+		
+		tempRoot, err := os.MkdirTemp("", "snapshot-bisect-")
+		if err != nil {
+			fmt.Fprintf(e.errOut, "❌ Failed to create a temp directory: %v\n", err)
+			return 1
+		}
+		fmt.Fprintf(e.out, "🔎 Bisecting between %s (good) and %s (bad); your working tree is not touched.\n", padNumber(good, SNAPSHOT_INDEX_WIDTH), padNumber(bad, SNAPSHOT_INDEX_WIDTH))
+		lastGood, firstBad, err := bisectSnapshots(listSnapshotIndices(snapshotsRoot), good, bad, func(index int) (bool, error) {
+			folder := findSnapshotByIndex(snapshotsRoot, index)
+			dest := filepath.Join(tempRoot, folder)
+			defer os.RemoveAll(dest)
+			count, err := e.extractSnapshot(filepath.Join(snapshotsRoot, folder), dest, mainIgnoreSet)
+			if err != nil {
+				return false, fmt.Errorf("failed to extract %s: %v", folder, err)
+			}
+			fmt.Fprintf(e.out, "\n📂 %s extracted to %s (%d file%s)\n", folder, dest, count, plural(count))
+			for {
+				answer, err := e.askUser("   Is this snapshot good or bad? (g/b): ")
+				if err != nil {
+					return false, fmt.Errorf("no answer for %s", folder)
+				}
+				switch strings.ToLower(answer) {
+				case "g", "good":
+					return true, nil
+				case "b", "bad":
+					return false, nil
+				}
+				fmt.Fprintln(e.out, "   Please answer g or b.")
+			}
+		})
+		os.RemoveAll(tempRoot)
+		if err != nil {
+			fmt.Fprintf(e.errOut, "\n❌ Bisect stopped: %v\n", err)
+			return 1
+		}
+		
+		fmt.Fprintf(e.out, "\n🎯 First bad snapshot: %s (last good: %s)\n", findSnapshotByIndex(snapshotsRoot, firstBad), findSnapshotByIndex(snapshotsRoot, lastGood))
+		answer, _ := e.askUser("   Run --analyze-regression on this pair? (y/N): ")
+		if !contains([]string{"y", "yes"}, strings.ToLower(answer)) {
+			fmt.Fprintf(e.out, "\n💡 Later: ./snapshot_v2 %s %s --analyze-regression\n", padNumber(lastGood, SNAPSHOT_INDEX_WIDTH), padNumber(firstBad, SNAPSHOT_INDEX_WIDTH))
+			return 0
+		}
+		fmt.Fprintln(e.out, "")
+		template, err := loadPromptTemplate(projectRoot, templatePath)
+		if err != nil {
+			fmt.Fprintf(e.errOut, "❌ Failed to read prompt template: %v\n", err)
+			return 1
+		}
+		if err := e.analyzeRegression(snapshotsRoot, projectRoot, mainIgnoreSet, diffOpts, lastGood, firstBad, template, summaryOnly, openAfter); err != nil {
+			fmt.Fprintf(e.errOut, "❌ %v\n", err)
+			return 1
+		}
+		return 0
+	}
+	
+	// Handle find command
+	if len(labelArgs) > 0 && labelArgs[0] == "find" {
+		if len(labelArgs) < 2 {
+			fmt.Fprintf(e.errOut, "❌ Usage: ./snapshot_v2 find \"text\"\n")
+			return 1
+		}
+		query := strings.Join(labelArgs[1:], " ")
+		matches := e.findSnapshotsByLabel(snapshotsRoot, query)
+		if len(matches) == 0 {
+			fmt.Fprintf(e.out, "📭 No snapshot labels contain %q.\n", query)
+			return 0
+		}
+		fmt.Fprintf(e.out, "🔎 Snapshots matching %q (%d):\n", query, len(matches))
+		for _, index := range matches {
+			folder := findSnapshotByIndex(snapshotsRoot, index)
+			created, _ := snapshotCreatedAt(snapshotsRoot, folder)
+			fmt.Fprintf(e.out, "  %s  %-40s %s\n", padNumber(index, SNAPSHOT_INDEX_WIDTH), snapshotDisplayLabel(snapshotsRoot, folder), created.Format("2006-01-02 15:04"))
+		}
+		if len(matches) == 1 {
+			fmt.Fprintf(e.out, "\n💡 Compare it with the working tree: ./snapshot_v2 %s --diff\n", padNumber(matches[0], SNAPSHOT_INDEX_WIDTH))
+		}
+		return 0
+	}
+	
+	// Handle rebuild-log command
+	if len(labelArgs) == 1 && labelArgs[0] == "rebuild-log" {
+		fmt.Fprintln(e.out, "🔧 Rebuilding snapshot.log from existing snapshots...")
+		count, backupPath, err := e.rebuildChangeManifest(snapshotsRoot, mainIgnoreSet)
+		if backupPath != "" {
+			fmt.Fprintf(e.out, "💾 Previous log backed up to %s\n", backupPath)
+		}
+		if err != nil {
+			fmt.Fprintf(e.errOut, "❌ Rebuild failed: %v\n", err)
+			return 1
+		}
+		fmt.Fprintf(e.out, "✅ Rebuilt snapshot.log from %d snapshot(s).\n", count)
+		return 0
+	}
+	
+	// Handle gc command
+	if len(labelArgs) == 1 && labelArgs[0] == "gc" {
+		orphans, size, err := findOrphanedArtifacts(snapshotsRoot)
+		if err != nil {
+			fmt.Fprintf(e.errOut, "❌ Failed to scan %s: %v\n", e.snapshotsDisplayPath(), err)
+			return 1
+		}
+		if len(orphans) == 0 {
+			fmt.Fprintln(e.out, "✅ No orphaned diff/prompt/regression files found.")
+			return 0
+		}
+		for _, name := range orphans {
+			if isDryRun {
+				fmt.Fprintf(e.out, "Would remove: %s\n", name)
+			} else if err := os.Remove(filepath.Join(snapshotsRoot, name)); err != nil {
+				fmt.Fprintf(e.errOut, "❌ Failed to remove %s: %v\n", name, err)
+				return 1
+			} else {
+				fmt.Fprintf(e.out, "Removed: %s\n", name)
+			}
+		}
+		if isDryRun {
+			fmt.Fprintf(e.out, "\n🧪 Dry run complete. %d file(s) would be removed, reclaiming %s.\n", len(orphans), formatBytes(size))
+		} else {
+			fmt.Fprintf(e.out, "\n🧹 Removed %d orphaned file(s), reclaiming %s.\n", len(orphans), formatBytes(size))
+		}
+		return 0
+	}
+	
+	// Handle amend command
+	if len(labelArgs) == 1 && labelArgs[0] == "amend" {
+		folder, stats, err := e.amendLatestSnapshot(snapshotsRoot, projectRoot, mainIgnoreSet, isForce)
+		if err != nil {
+			fmt.Fprintf(e.errOut, "❌ Amend failed: %v\n", err)
+			return 1
+		}
+		fmt.Fprintf(e.out, "✅ Amended %s: %d file%s, %s\n", folder, stats.Files, plural(stats.Files), formatBytes(stats.Bytes))
+		return 0
+	}
+	
+	// Handle prune command
+	if len(labelArgs) == 1 && labelArgs[0] == "prune" {
+		if keepCount == 0 && olderThan == 0 {
+			fmt.Fprintf(e.errOut, "❌ Usage: ./snapshot_v2 prune [--keep N] [--older-than 30d] [--dry-run]\n")
+			return 1
+		}
+		remove, held := planPrune(snapshotsRoot, keepCount, olderThan)
+		var heldIndices []int
+		for index := range held {
+			heldIndices = append(heldIndices, index)
+		}
+		sort.Ints(heldIndices)
+		for _, index := range heldIndices {
+			fmt.Fprintf(e.out, "🔗 Keeping %s: incremental snapshot %s still reads files from it\n", padNumber(index, SNAPSHOT_INDEX_WIDTH), padNumber(held[index], SNAPSHOT_INDEX_WIDTH))
+		}
+		if len(remove) == 0 {
+			fmt.Fprintln(e.out, "✅ Nothing to prune.")
+			return 0
+		}
+		for _, index := range remove {
+			folder := findSnapshotByIndex(snapshotsRoot, index)
+			if isDryRun {
+				fmt.Fprintf(e.out, "Would prune: %s\n", folder)
+				continue
+			}
+			if err := e.removeSnapshot(snapshotsRoot, index, folder); err != nil {
+				fmt.Fprintf(e.errOut, "❌ Failed to remove %s: %v\n", folder, err)
+				return 1
+			}
+			fmt.Fprintf(e.out, "Pruned: %s\n", folder)
+		}
+		if isDryRun {
+			fmt.Fprintf(e.out, "\n🧪 Dry run complete. %d snapshot(s) would be pruned.\n", len(remove))
+		} else {
+			fmt.Fprintf(e.out, "\n🧹 Pruned %d snapshot(s). Run ./snapshot_v2 gc to remove their old reports.\n", len(remove))
+		}
+		return 0
+	}
+	
+	// Handle log command
+	if len(labelArgs) == 1 && labelArgs[0] == "log" {
+		if err := e.printChangeManifest(snapshotsRoot, isOneline, logLimit); err != nil {
+			fmt.Fprintf(e.errOut, "❌ Failed to read snapshot log: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+	
+	// Handle verify command
+	if len(labelArgs) > 0 && labelArgs[0] == "verify" {
+		if len(labelArgs) < 2 {
+			fmt.Fprintf(e.errOut, "❌ Usage: ./snapshot_v2 verify NNNN\n")
+			return 1
+		}
+		verifyIndex, err := e.resolveSnapshot(snapshotsRoot, labelArgs[1])
+		if err != nil {
+			fmt.Fprintf(e.errOut, "❌ Invalid snapshot reference: %v\n", err)
+			return 1
+		}
+		verifyFolder := findSnapshotByIndex(snapshotsRoot, verifyIndex)
+		if verifyFolder == "" {
+			fmt.Fprintf(e.errOut, "❌ Snapshot %s not found\n", padNumber(verifyIndex, SNAPSHOT_INDEX_WIDTH))
+			return 1
+		}
+		
+		fmt.Fprintf(e.out, "🔍 Verifying snapshot: %s\n", verifyFolder)
+		problems, warnings, err := e.verifySnapshot(filepath.Join(snapshotsRoot, verifyFolder))
+		if err != nil {
+			fmt.Fprintf(e.errOut, "❌ Verify failed: %v\n", err)
+			return 1
+		}
+		for _, warning := range warnings {
+			fmt.Fprintln(e.out, "ℹ️  "+warning)
+		}
+		for _, problem := range problems {
+			fmt.Fprintln(e.out, "❌ "+problem)
+		}
+		if len(problems) > 0 {
+			fmt.Fprintf(e.out, "\n💥 Snapshot %s is damaged: %d problem(s) found.\n", padNumber(verifyIndex, SNAPSHOT_INDEX_WIDTH), len(problems))
+			return 1
+		}
+		fmt.Fprintln(e.out, "✅ Snapshot is intact.")
+		return 0
+	}
+	
+	// Handle --list (optionally filtered by --since/--before)
+	if hasList {
+		e.listSnapshots(snapshotsRoot, sinceWindow, beforeWindow)
+		return 0
+	}
+	
+	// Handle check-ignore PATH command (explain why a path is or isn't snapshotted)
+	if len(labelArgs) == 2 && labelArgs[0] == "check-ignore" {
+		target := labelArgs[1]
+		if filepath.IsAbs(target) {
+			if rel, err := filepath.Rel(projectRoot, target); err == nil {
+				target = rel
+			}
+		}
+		target = filepath.ToSlash(filepath.Clean(target))
+		
+		if pattern, source, ignored := matchIgnore(target, mainIgnoreSet); ignored {
+			fmt.Fprintf(e.out, "🚫 %s is ignored\n", target)
+			fmt.Fprintf(e.out, "   pattern: %s\n", pattern)
+			fmt.Fprintf(e.out, "   source:  %s\n", source)
+		} else if pattern, overridden := matchAlwaysOverride(target, mainIgnoreSet); overridden {
+			fmt.Fprintf(e.out, "✅ %s is included\n", target)
+			fmt.Fprintf(e.out, "   pattern: %s\n", pattern)
+			fmt.Fprintf(e.out, "   source:  %s\n", IGNORE_SOURCE_ALWAYS)
+		} else if exception, excepted := matchNeverException(target, mainIgnoreSet); excepted {
+			fmt.Fprintf(e.out, "✅ %s is included\n", target)
+			fmt.Fprintf(e.out, "   pattern: %s\n", exception)
+			fmt.Fprintf(e.out, "   source:  %s\n", IGNORE_SOURCE_EXCEPTION)
+		} else {
+			fmt.Fprintf(e.out, "✅ %s is included (no pattern matched)\n", target)
+		}
+		return 0
+	}
+	
+	// Handle check-ignore command (validate .snapshotignore)
+	if len(labelArgs) == 1 && labelArgs[0] == "check-ignore" {
+		fmt.Fprintln(e.out, "🔍 Checking .snapshotignore...")
+		problems, unmatched, err := e.validateSnapshotignore(projectRoot)
+		if err != nil {
+			fmt.Fprintf(e.errOut, "❌ Check failed: %v\n", err)
+			return 1
+		}
+		for _, problem := range problems {
+			fmt.Fprintln(e.out, "⚠️  "+problem)
+		}
+		for _, warning := range unmatched {
+			fmt.Fprintln(e.out, "ℹ️  "+warning)
+		}
+		if len(problems) > 0 {
+			fmt.Fprintf(e.out, "\n❌ Found %d problem(s) in .snapshotignore.\n", len(problems))
+			return 1
+		}
+		fmt.Fprintln(e.out, "✅ No structural problems found.")
+		return 0
+	}
+	
+	// Handle doctor command (cross-check ignore rules against the tree)
+	if len(labelArgs) == 1 && labelArgs[0] == "doctor" {
+		fmt.Fprintln(e.out, "🩺 Checking ignore rules against the project tree...")
+		problems, unmatched, err := e.validateSnapshotignore(projectRoot)
+		if err != nil && !os.IsNotExist(err) {
+			fmt.Fprintf(e.errOut, "❌ Check failed: %v\n", err)
+			return 1
+		}
+		reincluded, contradictions, err := e.diagnoseIgnoreRules(projectRoot, isDevMode)
+		if err != nil {
+			fmt.Fprintf(e.errOut, "❌ Check failed: %v\n", err)
+			return 1
+		}
+		
+		if len(reincluded) > 0 {
+			fmt.Fprintf(e.out, "\nℹ️  Ignored but re-included by ALWAYS SNAPSHOT (%d):\n", len(reincluded))
+			for _, line := range reincluded {
+				fmt.Fprintln(e.out, "  "+line)
+			}
+		}
+		if len(problems)+len(unmatched) > 0 {
+			fmt.Fprintf(e.out, "\n⚠️  Unreachable or misplaced patterns (%d):\n", len(problems)+len(unmatched))
+			for _, line := range append(problems, unmatched...) {
+				fmt.Fprintln(e.out, "  "+line)
+			}
+		}
+		if len(contradictions) > 0 {
+			fmt.Fprintf(e.out, "\n❌ Matched by both ALWAYS and NEVER SNAPSHOT (%d):\n", len(contradictions))
+			for _, line := range contradictions {
+				fmt.Fprintln(e.out, "  "+line)
+			}
+			fmt.Fprintln(e.out)
+			return 1
+		}
+		fmt.Fprintln(e.out, "\n✅ No contradictions found.")
+		return 0
+	}
+	
+	// Handle status command (working tree drift from the latest snapshot)
+	if len(labelArgs) > 0 && labelArgs[0] == "status" {
+		latestFolder := findSnapshotByIndex(snapshotsRoot, getNextSnapshotIndex(snapshotsRoot)-1)
+		if latestFolder == "" {
+			fmt.Fprintln(e.out, "ℹ️  No snapshots yet. Create one with: ./snapshot_v2 \"description\"")
+			return 0
+		}
+		
+		diffData, err := e.compareSnapshots(filepath.Join(snapshotsRoot, latestFolder), projectRoot, mainIgnoreSet, diffOpts)
+		if err != nil {
+			fmt.Fprintf(e.errOut, "❌ Status failed: %v\n", err)
+			return 1
+		}
+		
+		fmt.Fprintf(e.out, "📂 Latest snapshot: %s\n", latestFolder)
+		if len(diffData.Files) == 0 {
+			fmt.Fprintln(e.out, "✅ Working tree matches the latest snapshot.")
+			return 0
+		}
+		fmt.Fprintf(e.out, "🔍 %d file(s) changed since the latest snapshot:\n", len(diffData.Files))
+		fmt.Fprintln(e.out, "")
+		e.printStatus(diffData)
+		return 1
+	}
+	
+	// Handle range summary across consecutive snapshots
+	if hasRange {
+		if len(labelArgs) < 2 {
+			fmt.Fprintf(e.errOut, "❌ Please specify a start and end snapshot index for --range\n")
+			return 1
+		}
+		startIndex, err := e.resolveSnapshot(snapshotsRoot, labelArgs[0])
+		if err != nil {
+			fmt.Fprintf(e.errOut, "❌ Invalid snapshot reference: %v\n", err)
+			return 1
+		}
+		endIndex, err := e.resolveSnapshot(snapshotsRoot, labelArgs[1])
+		if err != nil {
+			fmt.Fprintf(e.errOut, "❌ Invalid snapshot reference: %v\n", err)
+			return 1
+		}
+		
+		fmt.Fprintf(e.out, "🔍 Summarizing changes across snapshots %s → %s...\n", padNumber(startIndex, SNAPSHOT_INDEX_WIDTH), padNumber(endIndex, SNAPSHOT_INDEX_WIDTH))
+		rangeData, err := e.compareSnapshotRange(snapshotsRoot, startIndex, endIndex, mainIgnoreSet, diffOpts)
+		if err != nil {
+			fmt.Fprintf(e.errOut, "❌ Range comparison failed: %v\n", err)
+			return 1
+		}
+		
+		rangeOutputPath := filepath.Join(snapshotsRoot, fmt.Sprintf("range_%s_to_%s.json", padNumber(startIndex, SNAPSHOT_INDEX_WIDTH), padNumber(endIndex, SNAPSHOT_INDEX_WIDTH)))
+		jsonData, _ := json.MarshalIndent(rangeData, "", "  ")
+		rangeOutputPath, _ = e.writeArtifact(rangeOutputPath, jsonData)
+		fmt.Fprintf(e.out, "✅ Range summary complete (%d files touched). Saved to %s\n", len(rangeData.Files), rangeOutputPath)
+		return 0
+	}
+	
+	// Handle regression analysis first (separate logic)
+	if hasAnalyzeRegression {
+		baseIndex, err := e.resolveSnapshot(snapshotsRoot, labelArgs[0])
+		if err != nil {
+			fmt.Fprintf(e.errOut, "❌ Invalid snapshot index: %v\n", err)
+			return 1
+		}
+		
+		basePaddedIndex := padNumber(baseIndex, SNAPSHOT_INDEX_WIDTH)
+		if findSnapshotByIndex(snapshotsRoot, baseIndex) == "" {
+			fmt.Fprintf(e.errOut, "❌ Base snapshot folder not found for index %d\n", baseIndex)
+			return 1
+		}
+		
+		// The first broken snapshot defaults to the one right after the known-good state
+		nextIndex := baseIndex + 1
+		if len(labelArgs) >= 2 {
+			nextIndex, err = e.resolveSnapshot(snapshotsRoot, labelArgs[1])
+			if err != nil {
+				fmt.Fprintf(e.errOut, "❌ Invalid snapshot reference: %v\n", err)
+				return 1
+			}
+			if nextIndex <= baseIndex {
+				fmt.Fprintf(e.errOut, "❌ The first broken snapshot (%s) must come after the known-good one (%s)\n", padNumber(nextIndex, SNAPSHOT_INDEX_WIDTH), basePaddedIndex)
+				return 1
+			}
+		}
+		nextFolder := findSnapshotByIndex(snapshotsRoot, nextIndex)
+		
+		if nextFolder == "" && len(labelArgs) >= 2 {
+			fmt.Fprintf(e.errOut, "❌ Snapshot folder not found for index %s\n", padNumber(nextIndex, SNAPSHOT_INDEX_WIDTH))
+			return 1
+		}
+		if nextFolder == "" {
+			fmt.Fprintf(e.errOut, "❌ No successor snapshot found. Snapshot %d appears to be the latest.\n", baseIndex)
+			fmt.Fprintf(e.errOut, "   Cannot analyze regression - need at least one snapshot after the known-good state.\n")
+			return 1
+		}
+		
+		if err := e.analyzeRegression(snapshotsRoot, projectRoot, mainIgnoreSet, diffOpts, baseIndex, nextIndex, promptTemplate, summaryOnly, openAfter); err != nil {
+			fmt.Fprintf(e.errOut, "❌ %v\n", err)
+			return 1
+		}
+		return 0
+	}
+	
+	// Three or more indices with --prompt: one prompt comparing each of them to the current code
+	if hasPrompt && !hasDiff && !hasRestore && len(labelArgs) >= 3 {
+		var diffs []*DiffResult
+		var indices, names []string
+		for _, ref := range labelArgs {
+			resolved, err := e.resolveSnapshot(snapshotsRoot, ref)
+			if err != nil {
+				fmt.Fprintf(e.errOut, "❌ Invalid snapshot reference: %v\n", err)
+				return 1
+			}
+			folder := findSnapshotByIndex(snapshotsRoot, resolved)
+			if folder == "" {
+				fmt.Fprintf(e.errOut, "❌ Snapshot folder not found for index %s\n", padNumber(resolved, SNAPSHOT_INDEX_WIDTH))
+				return 1
+			}
+			fmt.Fprintf(e.out, "🔍 Comparing %s against current working directory...\n", folder)
+			diffData, err := e.compareSnapshots(filepath.Join(snapshotsRoot, folder), projectRoot, mainIgnoreSet, diffOpts)
+			if err != nil {
+				fmt.Fprintf(e.errOut, "❌ Diff failed: %v\n", err)
+				return 1
+			}
+			diffs = append(diffs, diffData)
+			indices = append(indices, padNumber(resolved, SNAPSHOT_INDEX_WIDTH))
+			names = append(names, snapshotFolderLabel(folder))
+		}
+		promptPath, err := e.saveMultiBasePrompt(diffs, indices, names, snapshotsRoot)
+		if err != nil {
+			fmt.Fprintf(e.errOut, "❌ Failed to save prompt: %v\n", err)
+			return 1
+		}
+		if openAfter {
+			e.openInEditor(promptPath)
+		}
+		return 0
+	}
+	
+	if hasDiff || hasPrompt || hasRestore {
+		resolvedIndex1, err := e.resolveSnapshot(snapshotsRoot, labelArgs[0])
+		if err != nil {
+			fmt.Fprintf(e.errOut, "❌ Invalid snapshot reference: %v\n", err)
+			return 1
+		}
+		index1 := padNumber(resolvedIndex1, SNAPSHOT_INDEX_WIDTH)
+		matchingFolder1 := findSnapshotByIndex(snapshotsRoot, resolvedIndex1)
+		if matchingFolder1 == "" {
+			fmt.Fprintf(e.errOut, "❌ Snapshot folder not found for index %s\n", index1)
+			return 1
+		}
+		snapshotPath1 := filepath.Join(snapshotsRoot, matchingFolder1)
+		
+		if hasRestore {
+			// --preview-json keeps stdout for the JSON; everything else goes to stderr
+			info := e.out
+			if previewJSON {
+				info = e.errOut
+				isDryRun = true
+			}
+			restoreTarget := projectRoot
+			restoreMsg := fmt.Sprintf("♻️ Restoring snapshot: %s", matchingFolder1)
+			if intoDir != "" {
+				restoreTarget, _ = filepath.Abs(intoDir)
+				restoreMsg = fmt.Sprintf("📦 Extracting snapshot: %s into %s", matchingFolder1, restoreTarget)
+				if !isDryRun {
+					if err := os.MkdirAll(restoreTarget, 0755); err != nil {
+						fmt.Fprintf(e.errOut, "❌ Failed to create %s: %v\n", restoreTarget, err)
+						return 1
+					}
+				}
+			}
+			if isDryRun {
+				restoreMsg += " (dry run)"
+			}
+			fmt.Fprintln(info, restoreMsg)
+			// Partial (--git-changed, --max-depth) snapshots can only be merged back, never mirrored
+			if meta, ok := loadSnapshotMeta(snapshotPath1); ok && meta.Partial && isClean {
+				fmt.Fprintln(info, "⚠️  This is a partial snapshot (--git-changed or --max-depth); ignoring --clean so unrelated files are kept")
+				isClean = false
+			}
+			// Mirroring (deleting files outside the snapshot) is opt-in and never applies to --into targets
+			if isClean && restoreTarget == projectRoot {
+				fmt.Fprintln(info, "🧹 --clean: files not in the snapshot will be deleted")
+			}
+			var preview *[]RestoreAction
+			if previewJSON {
+				preview = &[]RestoreAction{}
+			}
+			if err := e.restoreSnapshot(snapshotPath1, restoreTarget, mainIgnoreSet, isDryRun, isClean && restoreTarget == projectRoot, showDiff, preview); err != nil {
+				fmt.Fprintf(e.errOut, "❌ Restore failed: %v\n", err)
+				return 1
+			}
+			if preview != nil {
+				jsonData, _ := json.MarshalIndent(*preview, "", "  ")
+				fmt.Fprintln(e.out, string(jsonData))
+			}
+			return 0
+		}
+		
+		// Check for two-snapshot comparison
+		var comparePath string
+		var diffOutputPath string
+		var compareIndex, compareName string
+		if againstDir != "" {
+			// Snapshot against an arbitrary directory: NNNN --diff --against DIR
+			if len(labelArgs) >= 2 {
+				fmt.Fprintf(e.errOut, "❌ --against compares one snapshot; don't pass a second index\n")
+				return 1
+			}
+			comparePath, _ = filepath.Abs(againstDir)
+			if info, err := os.Stat(comparePath); err != nil || !info.IsDir() {
+				fmt.Fprintf(e.errOut, "❌ --against directory not found: %s\n", againstDir)
+				return 1
+			}
+			diffOutputPath = filepath.Join(snapshotsRoot, fmt.Sprintf("diff_%s_to_external.json", index1))
+			if !nameMode {
+				fmt.Fprintf(e.out, "📂 Found snapshot: %s\n", matchingFolder1)
+				fmt.Fprintf(e.out, "🔍 Comparing against %s...\n", comparePath)
+			}
+		} else if len(labelArgs) >= 2 {
+			// Two snapshot comparison: NNNN MMMM --diff
+			resolvedIndex2, err := e.resolveSnapshot(snapshotsRoot, labelArgs[1])
+			if err != nil {
+				fmt.Fprintf(e.errOut, "❌ Invalid snapshot reference: %v\n", err)
+				return 1
+			}
+			index2 := padNumber(resolvedIndex2, SNAPSHOT_INDEX_WIDTH)
+			if index2 == index1 {
+				fmt.Fprintf(e.out, "ℹ️  Comparing snapshot %s to itself; nothing to diff.\n", index1)
+				return 0
+			}
+			matchingFolder2 := findSnapshotByIndex(snapshotsRoot, resolvedIndex2)
+			if matchingFolder2 == "" {
+				fmt.Fprintf(e.errOut, "❌ Snapshot folder not found for index %s\n", index2)
+				return 1
+			}
+			comparePath = filepath.Join(snapshotsRoot, matchingFolder2)
+			compareIndex = index2
+			compareName = snapshotFolderLabel(matchingFolder2)
+			diffOutputPath = filepath.Join(snapshotsRoot, fmt.Sprintf("diff_%s_to_%s.json", index1, index2))
+			if !nameMode {
+				fmt.Fprintf(e.out, "📂 Found snapshots: %s and %s\n", matchingFolder1, matchingFolder2)
+				fmt.Fprintf(e.out, "🔍 Comparing %s against %s...\n", matchingFolder1, matchingFolder2)
+			}
+		} else {
+			// Single snapshot comparison against current: NNNN --diff
+			comparePath = projectRoot
+			diffOutputPath = filepath.Join(snapshotsRoot, fmt.Sprintf("diff_%s_to_current.json", index1))
+			if !nameMode {
+				fmt.Fprintf(e.out, "📂 Found snapshot: %s\n", matchingFolder1)
+				fmt.Fprintln(e.out, "🔍 Comparing against current working directory...")
+			}
+		}
+		
+		// --json-lines writes each entry to stdout (and the saved .jsonl) as soon as it is known
+		var streamFile *os.File
+		if jsonLines {
+			if hasPrompt || hasHTML || hasPatch {
+				fmt.Fprintf(e.errOut, "❌ --json-lines can't be combined with --prompt, --html or --patch\n")
+				return 1
+			}
+			if !noSave {
+				if streamFile, err = os.Create(strings.TrimSuffix(diffOutputPath, ".json") + ".jsonl"); err != nil {
+					fmt.Fprintf(e.errOut, "❌ Failed to create %s: %v\n", strings.TrimSuffix(diffOutputPath, ".json")+".jsonl", err)
+					return 1
+				}
+			}
+			diffOpts.Stream = func(file DiffFile) error {
+				if len(onlyStatus) > 0 && !onlyStatus[file.Status] {
+					return nil
+				}
+				line, err := json.Marshal(file)
+				if err != nil {
+					return err
+				}
+				line = append(line, '\n')
+				if _, err := e.out.Write(line); err != nil {
+					return err
+				}
+				if streamFile != nil {
+					if _, err := streamFile.Write(line); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+		}
+		
+		diffData, err := e.compareSnapshots(snapshotPath1, comparePath, mainIgnoreSet, diffOpts)
+		if streamFile != nil {
+			if closeErr := streamFile.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(e.errOut, "❌ Diff failed: %v\n", err)
+			return 1
+		}
+		if jsonLines {
+			return 0
+		}
+		if len(onlyStatus) > 0 {
+			filterDiffByStatus(diffData, onlyStatus)
+		}
+		
+		if !noSave {
+			jsonData, _ := json.MarshalIndent(diffData, "", "  ")
+			savedPath, _ := e.writeArtifact(diffOutputPath, jsonData)
+			if !nameMode {
+				fmt.Fprintf(e.out, "✅ Diff complete. Saved to %s\n", savedPath)
+			}
+		}
+		
+		if nameMode {
+			for _, line := range formatNameList(diffData, hasNameStatus) {
+				fmt.Fprintln(e.out, line)
+			}
+		} else if hasStat {
+			fmt.Fprintln(e.out, "")
+			for _, line := range formatDiffStat(diffData) {
+				fmt.Fprintln(e.out, line)
+			}
+		} else if hasDiff {
+			fmt.Fprintln(e.out, "")
+			e.printPaged(e.formatDiffSummary(diffData))
+		}
+		
+		if hasHTML {
+			if err := e.saveHTMLReport(diffData, strings.TrimSuffix(diffOutputPath, ".json")+".html"); err != nil {
+				fmt.Fprintf(e.errOut, "❌ Failed to write HTML report: %v\n", err)
+				return 1
+			}
+		}
+		
+		if hasPatch {
+			patchPath := strings.TrimSuffix(diffOutputPath, ".json") + ".patch"
+			if err := os.WriteFile(patchPath, []byte(e.buildPatch(diffData, snapshotPath1, comparePath)), 0644); err != nil {
+				fmt.Fprintf(e.errOut, "❌ Failed to write patch: %v\n", err)
+				return 1
+			}
+			fmt.Fprintf(e.out, "✅ Patch saved to %s (apply with git apply or patch -p1)\n", patchPath)
+		}
+		
+		if hasPrompt {
+			snapshotName := snapshotFolderLabel(matchingFolder1)
+			promptPath, err := e.savePrompt(diffData, index1, snapshotName, compareIndex, compareName, snapshotsRoot, maxTokens, promptTemplate)
+			if err != nil {
+				fmt.Fprintf(e.errOut, "❌ Failed to write prompt: %v\n", err)
+				return 1
+			}
+			if openAfter {
+				e.openInEditor(promptPath)
+			}
+		} else if openAfter {
+			if noSave {
+				fmt.Fprintln(e.out, "ℹ️  --open: nothing to open with --no-save")
+			} else if e.gzipArtifacts {
+				e.openInEditor(diffOutputPath + ".gz")
+			} else {
+				e.openInEditor(diffOutputPath)
+			}
+		}
+		return 0
+	}
+	
+	if len(escapedLabel) > 0 {
+		labelArgs = escapedLabel
+	}
+	if len(labelArgs) == 0 && !stdinLabel {
+		fmt.Fprintf(e.errOut, "❌ Please provide a snapshot label or use --diff/--prompt/--restore with a snapshot index.\n")
+		return 1
+	}
+	
+	labelRaw := strings.Join(labelArgs, " ")
+	// --stdin-label (or a label of "-") reads a multi-line description; its first line names the folder
+	if stdinLabel || labelRaw == "-" {
+		content, err := io.ReadAll(e.stdinReader)
+		if err != nil {
+			fmt.Fprintf(e.errOut, "❌ Failed to read the snapshot description from stdin: %v\n", err)
+			return 1
+		}
+		labelRaw = strings.TrimSpace(strings.ReplaceAll(string(content), "\r\n", "\n"))
+		if labelRaw == "" {
+			fmt.Fprintf(e.errOut, "❌ No snapshot description on stdin\n")
+			return 1
+		}
+	}
+	scope := ""
+	if scopeDir != "" {
+		absScope, _ := filepath.Abs(scopeDir)
+		relScope, err := filepath.Rel(projectRoot, absScope)
+		if err != nil || relScope == ".." || strings.HasPrefix(relScope, ".."+string(filepath.Separator)) {
+			fmt.Fprintf(e.errOut, "❌ --path must be inside the project: %s\n", scopeDir)
+			return 1
+		}
+		if info, err := os.Stat(absScope); err != nil || !info.IsDir() {
+			fmt.Fprintf(e.errOut, "❌ --path is not a directory: %s\n", scopeDir)
+			return 1
+		}
+		if relScope != "." {
+			scope = filepath.ToSlash(relScope)
+		}
+	}
+	
+	request := snapshotRequest{
+		label:       labelRaw,
+		forceIndex:  forceIndex,
+		force:       isForce,
+		dryRun:      isDryRun,
+		scope:       scope,
+		gitChanged:  isGitChanged,
+		incremental: isIncremental,
+		allowEmpty:  allowEmpty,
+		encrypt:     encrypt,
+		retention:   config.Retention,
+	}
+	if _, _, err := e.createSnapshot(projectRoot, snapshotsRoot, mainIgnoreSet, request); err != nil {
+		if errors.Is(err, errSnapshotCancelled) {
+			return 0
+		}
+		fmt.Fprintf(e.errOut, "❌ %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// ErrNoChanges is returned when a new snapshot would be identical to the previous one
+var ErrNoChanges = errors.New("no changes since the previous snapshot")
+
+// errSnapshotCancelled reports that the user declined to create a snapshot identical to the previous one
+var errSnapshotCancelled = errors.New("snapshot cancelled")
+
+// snapshotRequest describes one snapshot to create (see createSnapshot)
+type snapshotRequest struct {
+	label       string           // as typed; the folder name holds the sanitized first line
+	forceIndex  int              // create as this index instead of the next free one (0 = next)
+	force       bool             // allow a copy of the previous snapshot, or replacing forceIndex
+	dryRun      bool             // list the files that would be copied and stop
+	scope       string           // slash-separated subtree to capture ("" = the whole project)
+	gitChanged  bool             // capture only files git reports as changed
+	incremental bool             // reference files unchanged since the previous snapshot
+	allowEmpty  bool             // allow a snapshot with no files
+	encrypt     bool             // seal file contents under a passphrase
+	retention   *RetentionPolicy // prune older snapshots afterwards (nil = keep all)
+}
+
+// Capture the project as a new snapshot and return its index and copy statistics. The snapshot is built in
+// a temp directory and moved into place only once complete, so a failure never leaves a half-made snapshot.
+func (e *engine) createSnapshot(projectRoot, snapshotsRoot string, ignoreSet *IgnoreSet, request snapshotRequest) (int, CopyStats, error) {
+	label := sanitizeLabel(strings.SplitN(request.label, "\n", 2)[0])
+	nextIndex := getNextSnapshotIndex(snapshotsRoot)
+	
+	// A pinned index may only replace an existing snapshot when forced
+	existingFolder := ""
+	if request.forceIndex > 0 {
+		nextIndex = request.forceIndex
+		existingFolder = findSnapshotByIndex(snapshotsRoot, request.forceIndex)
+		if existingFolder != "" && !request.force {
+			return 0, CopyStats{}, fmt.Errorf("snapshot %s already exists (use --force to overwrite it)", existingFolder)
+		}
+	}
+	prefix := padNumber(nextIndex, SNAPSHOT_INDEX_WIDTH)
+	folderName := prefix + "_" + label
+	snapshotDir := filepath.Join(snapshotsRoot, folderName)
+	
+	if request.dryRun {
+		fmt.Fprintf(e.out, "🧪 Dry run: would create snapshot %s\n", snapshotDir)
+	} else {
+		fmt.Fprintf(e.out, "📸 Creating snapshot: %s\n", snapshotDir)
+	}
+	scopeRoot := filepath.Join(projectRoot, filepath.FromSlash(request.scope))
+	
+	// Pre-flight: make sure the copy fits before writing anything
+	var filesToCopy []string
+	var err error
+	if request.gitChanged {
+		filesToCopy, err = e.gitChangedFiles(projectRoot, ignoreSet)
+		filesToCopy = filterToScope(filesToCopy, request.scope)
+	} else {
+		filesToCopy, err = e.listFilesRecursively(scopeRoot, projectRoot, ignoreSet)
+	}
+	if err != nil {
+		return 0, CopyStats{}, fmt.Errorf("failed to list project files: %v", err)
+	}
+	// An empty file list almost always means a catch-all NEVER SNAPSHOT pattern
+	if len(filesToCopy) == 0 && !request.gitChanged && !request.allowEmpty {
+		return 0, CopyStats{}, fmt.Errorf("your ignore rules excluded all files; check .snapshotignore (or pass --allow-empty)")
+	}
+	requiredBytes := totalFileSize(projectRoot, filesToCopy)
+	
+	// Dry run: show what would be captured and stop before writing anything
+	if request.dryRun {
+		fmt.Fprintln(e.out, "")
+		for _, file := range filesToCopy {
+			fmt.Fprintln(e.out, "  "+filepath.ToSlash(file))
+		}
+		fmt.Fprintln(e.out, "")
+		fmt.Fprintf(e.out, "🧪 Dry run complete. %d file%s (%s) would be copied into %s.\n", len(filesToCopy), plural(len(filesToCopy)), formatBytes(int64(requiredBytes)), folderName)
+		return nextIndex, CopyStats{}, nil
+	}
+	
+	if request.incremental && request.gitChanged {
+		return 0, CopyStats{}, fmt.Errorf("--incremental can't be combined with --git-changed")
+	}
+	
+	// Guard against accidental duplicate snapshots, comparing the working tree with the previous
+	// snapshot before anything is copied (--git-changed snapshots only hold a few files, so never match)
+	previousFolder := findSnapshotByIndex(snapshotsRoot, nextIndex-1)
+	var previousDiff *DiffResult
+	if previousFolder != "" && !request.force && !request.gitChanged {
+		if diffData, err := e.compareSnapshots(filepath.Join(snapshotsRoot, previousFolder), projectRoot, ignoreSet, DiffOptions{}); err == nil {
+			previousDiff = diffData
+		}
+		if previousDiff != nil && !e.snapshotWouldDiffer(previousDiff, projectRoot, request.scope) {
+			previousIndex := padNumber(nextIndex-1, SNAPSHOT_INDEX_WIDTH)
+			if !e.interactive {
+				return 0, CopyStats{}, fmt.Errorf("%w (%s); use --force to create it anyway", ErrNoChanges, previousIndex)
+			}
+			answer, err := e.askUser(fmt.Sprintf("⚠️  No changes since %s. Create anyway? (y/N): ", previousIndex))
+			if err != nil {
+				return 0, CopyStats{}, fmt.Errorf("%w (%s), and no answer to create it anyway: %v", ErrNoChanges, previousIndex, err)
+			}
+			if !contains([]string{"y", "yes"}, strings.ToLower(answer)) {
+				fmt.Fprintln(e.out, "🚫 Snapshot cancelled (use --force to create duplicate snapshots).")
+				return 0, CopyStats{}, errSnapshotCancelled
+			}
+		}
+	}
+	
+	// Incremental: files unchanged since the previous snapshot are referenced rather than copied
+	var reuse map[string]FileIndexEntry
+	parentIndex := 0
+	if request.incremental {
+		parentFolder := previousFolder
+		var parentFiles map[string]FileIndexEntry
+		if parentFolder != "" {
+			parentFiles = loadFileIndex(filepath.Join(snapshotsRoot, parentFolder))
+		}
+		if parentFiles == nil {
+			fmt.Fprintln(e.out, "⚠️  No previous snapshot with a file index; creating a full snapshot instead.")
+		} else {
+			// Reuse the duplicate check's comparison when it ran
+			parentDiff := previousDiff
+			if parentDiff == nil {
+				if parentDiff, err = e.compareSnapshots(filepath.Join(snapshotsRoot, parentFolder), projectRoot, ignoreSet, DiffOptions{}); err != nil {
+					return 0, CopyStats{}, fmt.Errorf("failed to compare with %s: %v", parentFolder, err)
+				}
+			}
+			changed := make(map[string]bool)
+			for _, file := range parentDiff.Files {
+				changed[file.File] = true
+			}
+			parentIndex = nextIndex - 1
+			reuse = make(map[string]FileIndexEntry)
+			for _, file := range filesToCopy {
+				key := filepath.ToSlash(file)
+				entry, ok := parentFiles[key]
+				if !ok || changed[key] {
+					continue
+				}
+				// Point straight at the snapshot that physically holds the content
+				if entry.Ref == 0 {
+					entry.Ref = parentIndex
+				}
+				reuse[key] = entry
+				requiredBytes -= uint64(entry.Size)
+			}
+		}
+	}
+	
+	if available, err := availableDiskSpace(snapshotsRoot); err == nil && requiredBytes > available {
+		return 0, CopyStats{}, fmt.Errorf("not enough disk space: snapshot needs %.1f MB but only %.1f MB is available",
+			float64(requiredBytes)/(1024*1024), float64(available)/(1024*1024))
+	}
+	
+	// Seal file contents under a fresh salt; the passphrase comes from PASSPHRASE_ENV_VAR or a prompt
+	if request.encrypt {
+		e.encryptionSalt = make([]byte, ENCRYPTION_SALT_SIZE)
+		if _, err := rand.Read(e.encryptionSalt); err != nil {
+			return 0, CopyStats{}, fmt.Errorf("failed to generate an encryption salt: %v", err)
+		}
+		if err := e.requirePassphrase(); err != nil {
+			return 0, CopyStats{}, err
+		}
+		key, err := e.deriveKey(e.encryptionSalt)
+		if err != nil {
+			return 0, CopyStats{}, err
+		}
+		e.encryptionKey = key
+	}
+	
+	// Build the snapshot in a temp directory so an interrupted copy never looks like a real snapshot
+	tempDir := filepath.Join(snapshotsRoot, ".tmp-"+prefix)
+	os.RemoveAll(tempDir)
+	trackPartialSnapshot(tempDir, true)
+	defer trackPartialSnapshot(tempDir, false)
+	
+	// Clean up the temp directory on any failure before it is moved into place
+	fail := func(stats CopyStats, format string, a ...interface{}) (int, CopyStats, error) {
+		os.RemoveAll(tempDir)
+		return 0, stats, fmt.Errorf(format, a...)
+	}
+	
+	err = os.MkdirAll(tempDir, 0755)
+	if err != nil {
+		return fail(CopyStats{}, "failed to create snapshot directory: %v", err)
+	}
+	
+	copyProgress := e.newProgress("Copying", len(filesToCopy))
+	var copyStats CopyStats
+	if request.gitChanged {
+		copyStats, err = e.copyFiles(projectRoot, tempDir, filesToCopy, copyProgress)
+	} else {
+		scopeDest := filepath.Join(tempDir, filepath.FromSlash(request.scope))
+		if err = os.MkdirAll(scopeDest, 0755); err == nil {
+			copyStats, err = e.copyDir(scopeRoot, scopeDest, ignoreSet, projectRoot, reuse, copyProgress)
+		}
+	}
+	copyProgress.Clear()
+	if err != nil {
+		return fail(copyStats, "failed to copy files: %v\n   Removed partial snapshot directory %s", err, tempDir)
+	}
+	
+	err = writeFileIndex(tempDir, copyStats.Index)
+	if err != nil {
+		return fail(copyStats, "failed to write file index: %v", err)
+	}
+	
+	// Keep the label as typed; the folder name only holds the sanitized form
+	sort.Strings(copyStats.EmptyDirs)
+	meta := SnapshotMeta{Label: request.label, CreatedAt: time.Now(), EmptyDirs: copyStats.EmptyDirs, Partial: request.gitChanged || e.maxDepth >= 0, Parent: parentIndex, Scope: request.scope}
+	if e.encryptionKey != nil {
+		meta.Salt = hex.EncodeToString(e.encryptionSalt)
+	}
+	if commit, dirty, ok := gitState(projectRoot); ok {
+		meta.GitCommit, meta.GitDirty = commit, dirty
+	}
+	err = writeSnapshotMeta(tempDir, meta)
+	if err != nil {
+		return fail(copyStats, "failed to write snapshot metadata: %v", err)
+	}
+	
+	if existingFolder != "" {
+		// Overwriting a --force-index target: swap the old snapshot out, then fix its log entry
+		oldDir := filepath.Join(snapshotsRoot, ".replaced-"+prefix)
+		os.RemoveAll(oldDir)
+		if err := os.Rename(filepath.Join(snapshotsRoot, existingFolder), oldDir); err != nil {
+			return fail(copyStats, "failed to replace %s: %v", existingFolder, err)
+		}
+		if err := os.Rename(tempDir, snapshotDir); err != nil {
+			os.Rename(oldDir, filepath.Join(snapshotsRoot, existingFolder))
+			return fail(copyStats, "failed to finalize snapshot directory: %v", err)
+		}
+		os.RemoveAll(oldDir)
+		if err := e.replaceChangeManifest(snapshotsRoot, snapshotDir, nextIndex, request.label, meta.CreatedAt, ignoreSet); err != nil {
+			fmt.Fprintf(e.errOut, "⚠️  Failed to update change manifest: %v\n", err)
+		}
+	} else {
+		err = e.appendChangeManifest(snapshotsRoot, tempDir, nextIndex, request.label, meta.CreatedAt, ignoreSet)
+		if err != nil {
+			return fail(copyStats, "failed to update change manifest: %v", err)
+		}
+		
+		err = os.Rename(tempDir, snapshotDir)
+		if err != nil {
+			return fail(copyStats, "failed to finalize snapshot directory: %v", err)
+		}
+	}
+	
+	fmt.Fprintf(e.out, "✅ Snapshot complete: %d file%s, %s\n", copyStats.Files, plural(copyStats.Files), formatBytes(copyStats.Bytes))
+	if copyStats.Referenced > 0 {
+		fmt.Fprintf(e.out, "   🔗 %d unchanged file%s referenced from earlier snapshots\n", copyStats.Referenced, plural(copyStats.Referenced))
+	}
+	if copyStats.Skipped > 0 {
+		fmt.Fprintf(e.out, "   ⏭️  Skipped %d file%s larger than %s (use --verbose to list them)\n", copyStats.Skipped, plural(copyStats.Skipped), formatBytes(e.maxFileSize))
+	}
+	if len(copyStats.Secrets) > 0 {
+		sort.Strings(copyStats.Secrets)
+		fmt.Fprintf(e.out, "   🔐 Left out %d file%s that may contain secrets:\n", len(copyStats.Secrets), plural(len(copyStats.Secrets)))
+		for _, secret := range copyStats.Secrets {
+			fmt.Fprintf(e.out, "      %s\n", secret)
+		}
+	}
+	if request.retention != nil {
+		e.applyRetention(snapshotsRoot, *request.retention)
+	}
+	return nextIndex, copyStats, nil
+}
+
+// Check whether a snapshot of the working tree would differ from the snapshot it was compared with.
+// Only changes inside scope count, and added files that --scan-secrets will leave out don't.
+func (e *engine) snapshotWouldDiffer(diffData *DiffResult, projectRoot, scope string) bool {
+	for _, file := range diffData.Files {
+		if scope != "" && !withinScope(file.File, scope) {
+			continue
+		}
+		if file.Status == "added" && e.secretPatterns != nil && e.findSecret(filepath.Join(projectRoot, filepath.FromSlash(file.File))) != "" {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// Temp directories of snapshots being built, removed by CleanupInterrupted when the process is stopped
+var partialSnapshots = make(map[string]bool)
+var partialSnapshotsMu sync.Mutex
+
+// Register (or, once it has been moved into place or removed, forget) a snapshot temp directory
+func trackPartialSnapshot(tempDir string, building bool) {
+	partialSnapshotsMu.Lock()
+	defer partialSnapshotsMu.Unlock()
+	if building {
+		partialSnapshots[tempDir] = true
+	} else {
+		delete(partialSnapshots, tempDir)
+	}
+}
+
+// CleanupInterrupted removes the temp directories of snapshots still being built and returns
+// their paths. Call it from a signal handler before exiting.
+func CleanupInterrupted() []string {
+	partialSnapshotsMu.Lock()
+	defer partialSnapshotsMu.Unlock()
+	var removed []string
+	for tempDir := range partialSnapshots {
+		os.RemoveAll(tempDir)
+		removed = append(removed, tempDir)
+	}
+	sort.Strings(removed)
+	return removed
+}
+
+// Write the causal (known good → first broken) and cumulative (known good → current) diffs
+// and the two-part regression prompt built from them
+func (e *engine) analyzeRegression(snapshotsRoot, projectRoot string, ignoreSet *IgnoreSet, opts DiffOptions, baseIndex, nextIndex int, template string, summaryOnly, openAfter bool) error {
+	baseFolder := findSnapshotByIndex(snapshotsRoot, baseIndex)
+	nextFolder := findSnapshotByIndex(snapshotsRoot, nextIndex)
+	if baseFolder == "" || nextFolder == "" {
+		return fmt.Errorf("snapshot folder not found for index %s or %s", padNumber(baseIndex, SNAPSHOT_INDEX_WIDTH), padNumber(nextIndex, SNAPSHOT_INDEX_WIDTH))
+	}
+	
+	basePath := filepath.Join(snapshotsRoot, baseFolder)
+	nextPath := filepath.Join(snapshotsRoot, nextFolder)
+	basePaddedIndex := padNumber(baseIndex, SNAPSHOT_INDEX_WIDTH)
+	nextPaddedIndex := padNumber(nextIndex, SNAPSHOT_INDEX_WIDTH)
+	
+	fmt.Fprintln(e.out, "🔍 Starting regression analysis...")
+	fmt.Fprintf(e.out, "📂 Base (known good): %s\n", baseFolder)
+	fmt.Fprintf(e.out, "📁 Next (first broken): %s\n", nextFolder)
+	fmt.Fprintln(e.out, "")
+	
+	// Generate Causal Diff (known good vs first broken)
+	fmt.Fprintf(e.out, "⚡ Analyzing causal diff (%s → %s)...\n", basePaddedIndex, nextPaddedIndex)
+	causalDiff, err := e.compareSnapshots(basePath, nextPath, ignoreSet, opts)
+	if err != nil {
+		return fmt.Errorf("failed to generate causal diff: %v", err)
+	}
+	
+	// Generate Cumulative Diff (NNNN vs current)
+	fmt.Fprintf(e.out, "🌐 Analyzing cumulative diff (%s → current)...\n", basePaddedIndex)
+	cumulativeDiff, err := e.compareSnapshots(basePath, projectRoot, ignoreSet, opts)
+	if err != nil {
+		return fmt.Errorf("failed to generate cumulative diff: %v", err)
+	}
+	
+	// Save both diffs as JSON
+	causalDiffPath := filepath.Join(snapshotsRoot, fmt.Sprintf("regression_causal_%s_to_%s.json", basePaddedIndex, nextPaddedIndex))
+	cumulativeDiffPath := filepath.Join(snapshotsRoot, fmt.Sprintf("regression_cumulative_%s_to_current.json", basePaddedIndex))
+	
+	causalJSON, _ := json.MarshalIndent(causalDiff, "", "  ")
+	cumulativeJSON, _ := json.MarshalIndent(cumulativeDiff, "", "  ")
+	
+	causalDiffPath, _ = e.writeArtifact(causalDiffPath, causalJSON)
+	cumulativeDiffPath, _ = e.writeArtifact(cumulativeDiffPath, cumulativeJSON)
+	
+	fmt.Fprintf(e.out, "✅ Causal diff saved to %s\n", causalDiffPath)
+	fmt.Fprintf(e.out, "✅ Cumulative diff saved to %s\n", cumulativeDiffPath)
+	
+	// Generate the two-part regression analysis prompt
+	promptPath, err := e.saveRegressionAnalysisPrompt(causalDiff, cumulativeDiff, basePaddedIndex, snapshotFolderLabel(baseFolder), nextPaddedIndex, snapshotFolderLabel(nextFolder), snapshotsRoot, template, summaryOnly)
+	if err != nil {
+		return fmt.Errorf("failed to write regression analysis prompt: %v", err)
+	}
+	if openAfter {
+		e.openInEditor(promptPath)
+	}
+	
+	fmt.Fprintln(e.out, "")
+	fmt.Fprintln(e.out, "🎯 Regression analysis complete! Use the generated prompt with your LLM to identify the root cause and solution.")
+	return nil
+}
+
+// Describe how long ago a time was, e.g. "5 minutes ago"
+func formatAge(t time.Time) string {
+	age := time.Since(t)
+	switch {
+	case age < time.Minute:
+		return "just now"
+	case age < time.Hour:
+		return fmt.Sprintf("%d minute(s) ago", int(age.Minutes()))
+	case age < 24*time.Hour:
+		return fmt.Sprintf("%d hour(s) ago", int(age.Hours()))
+	default:
+		return fmt.Sprintf("%d day(s) ago", int(age.Hours()/24))
+	}
+}
+
+// Parse an age window such as "30m", "6h", "3d" or "2w"
+func parseAgeDuration(value string) (time.Duration, error) {
+	units := map[byte]time.Duration{
+		'm': time.Minute,
+		'h': time.Hour,
+		'd': 24 * time.Hour,
+		'w': 7 * 24 * time.Hour,
+	}
+	trimmed := strings.ToLower(strings.TrimSpace(value))
+	if len(trimmed) < 2 {
+		return 0, fmt.Errorf("invalid duration %q (expected e.g. 30m, 6h, 3d, 2w)", value)
+	}
+	unit, ok := units[trimmed[len(trimmed)-1]]
+	count, err := strconv.Atoi(trimmed[:len(trimmed)-1])
+	if !ok || err != nil || count < 0 {
+		return 0, fmt.Errorf("invalid duration %q (expected e.g. 30m, 6h, 3d, 2w)", value)
+	}
+	return time.Duration(count) * unit, nil
+}
+
+// Pack a snapshot into a .tar.gz archive. Incremental references are resolved so the
+// archive stands on its own. Returns the number of files written.
+func (e *engine) exportSnapshot(snapshotPath, archivePath string) (int, error) {
+	onDisk, err := e.listFilesRecursively(snapshotPath, snapshotPath, newIgnoreSet())
+	if err != nil {
+		return 0, err
+	}
+	index := loadFileIndex(snapshotPath)
+	resolve := snapshotFileResolver(snapshotPath, index)
+	fileSet := make(map[string]bool)
+	for _, relPath := range onDisk {
+		fileSet[filepath.ToSlash(relPath)] = true
+	}
+	for relPath := range index {
+		fileSet[relPath] = true
+	}
+	var files []string
+	for relPath := range fileSet {
+		files = append(files, relPath)
+	}
+	sort.Strings(files)
+	
+	out, err := os.Create(archivePath)
+	if err != nil {
+		return 0, err
+	}
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+	fail := func(err error) (int, error) {
+		tw.Close()
+		gz.Close()
+		out.Close()
+		os.Remove(archivePath)
+		return 0, err
+	}
+	addBytes := func(name string, content []byte) error {
+		header := &tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), ModTime: time.Now()}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err := tw.Write(content)
+		return err
+	}
+	
+	for _, relPath := range files {
+		filePath := resolve(relPath)
+		info, err := os.Stat(filePath)
+		if err != nil {
+			return fail(fmt.Errorf("%s: %v", relPath, err))
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return fail(err)
+		}
+		header.Name = relPath
+		if err := tw.WriteHeader(header); err != nil {
+			return fail(err)
+		}
+		file, err := os.Open(filePath)
+		if err != nil {
+			return fail(err)
+		}
+		_, err = io.Copy(tw, file)
+		file.Close()
+		if err != nil {
+			return fail(err)
+		}
+	}
+	
+	// Empty directories have no files to carry them
+	for _, dir := range snapshotEmptyDirs(snapshotPath, newIgnoreSet()) {
+		if err := tw.WriteHeader(&tar.Header{Name: dir + "/", Typeflag: tar.TypeDir, Mode: 0755, ModTime: time.Now()}); err != nil {
+			return fail(err)
+		}
+	}
+	
+	// Index and metadata go in as-is, except that references to other snapshots no longer apply
+	if index != nil {
+		for relPath, entry := range index {
+			entry.Ref = 0
+			index[relPath] = entry
+		}
+		indexJSON, _ := json.MarshalIndent(index, "", "  ")
+		if err := addBytes(SNAPSHOT_INDEX_FILE, indexJSON); err != nil {
+			return fail(err)
+		}
+	}
+	if meta, ok := loadSnapshotMeta(snapshotPath); ok {
+		meta.Parent = 0
+		metaJSON, _ := json.MarshalIndent(meta, "", "  ")
+		if err := addBytes(SNAPSHOT_META_FILE, metaJSON); err != nil {
+			return fail(err)
+		}
+	}
+	
+	if err := tw.Close(); err != nil {
+		return fail(err)
+	}
+	if err := gz.Close(); err != nil {
+		return fail(err)
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(archivePath)
+		return 0, err
+	}
+	return len(files), nil
+}
+
+// Open a .tar.gz archive for reading; call close when done
+func openSnapshotArchive(archivePath string) (*tar.Reader, func(), error) {
+	in, err := os.Open(archivePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	gz, err := gzip.NewReader(in)
+	if err != nil {
+		in.Close()
+		return nil, nil, fmt.Errorf("%s is not a .tar.gz archive: %v", archivePath, err)
+	}
+	return tar.NewReader(gz), func() { gz.Close(); in.Close() }, nil
+}
+
+// Turn an archive entry name into a relative path, rejecting absolute paths and "../" traversal
+func archiveEntryPath(name string) (string, error) {
+	cleaned := filepath.Clean(filepath.FromSlash(name))
+	if filepath.IsAbs(cleaned) || strings.HasPrefix(filepath.ToSlash(name), "/") || filepath.VolumeName(cleaned) != "" ||
+		cleaned == ".." || strings.HasPrefix(cleaned, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("unsafe path in archive: %s", name)
+	}
+	return cleaned, nil
+}
+
+// Check every entry of an archive before anything is extracted
+func validateSnapshotArchive(archivePath string) error {
+	tr, closeArchive, err := openSnapshotArchive(archivePath)
+	if err != nil {
+		return err
+	}
+	defer closeArchive()
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := archiveEntryPath(header.Name); err != nil {
+			return err
+		}
+	}
+}
+
+// Unpack an exported .tar.gz into dest after validating it; links and other special entries are skipped
+func extractSnapshotArchive(archivePath, dest string) (int, error) {
+	if err := validateSnapshotArchive(archivePath); err != nil {
+		return 0, err
+	}
+	tr, closeArchive, err := openSnapshotArchive(archivePath)
+	if err != nil {
+		return 0, err
+	}
+	defer closeArchive()
+	
+	count := 0
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return count, err
+		}
+		name, err := archiveEntryPath(header.Name)
+		if err != nil {
+			return count, err
+		}
+		target, err := containedPath(dest, name)
+		if err != nil {
+			return count, err
+		}
+		
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return count, err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return count, err
+			}
+			out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, header.FileInfo().Mode().Perm())
+			if err != nil {
+				return count, err
+			}
+			_, err = io.Copy(out, tr)
+			out.Close()
+			if err != nil {
+				return count, err
+			}
+			os.Chtimes(target, header.ModTime, header.ModTime)
+			if name != SNAPSHOT_INDEX_FILE && name != SNAPSHOT_META_FILE {
+				count++
+			}
+		}
+	}
+	return count, detachImportedSnapshot(dest)
+}
+
+// An imported snapshot must be self-contained: references into other snapshots (which would point at
+// whatever unrelated snapshot has that index here) are dropped, as are index entries for files the
+// archive didn't carry
+func detachImportedSnapshot(snapshotPath string) error {
+	if index := loadFileIndex(snapshotPath); index != nil {
+		for relPath, entry := range index {
+			if _, err := os.Stat(filepath.Join(snapshotPath, filepath.FromSlash(relPath))); err != nil {
+				delete(index, relPath)
+				continue
+			}
+			entry.Ref = 0
+			index[relPath] = entry
+		}
+		if err := writeFileIndex(snapshotPath, index); err != nil {
+			return err
+		}
+	}
+	if meta, ok := loadSnapshotMeta(snapshotPath); ok && meta.Parent != 0 {
+		meta.Parent = 0
+		return writeSnapshotMeta(snapshotPath, meta)
+	}
+	return nil
+}
+
+// Write a snapshot's metadata file
+func writeSnapshotMeta(snapshotPath string, meta SnapshotMeta) error {
+	metaJSON, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(snapshotPath, SNAPSHOT_META_FILE), metaJSON, 0644)
+}
+
+// Load a snapshot's metadata; ok is false for snapshots created without it
+func loadSnapshotMeta(snapshotPath string) (SnapshotMeta, bool) {
+	var meta SnapshotMeta
+	content, err := os.ReadFile(filepath.Join(snapshotPath, SNAPSHOT_META_FILE))
+	if err != nil {
+		return meta, false
+	}
+	if err := json.Unmarshal(content, &meta); err != nil {
+		return meta, false
+	}
+	return meta, true
+}
+
+// A snapshot's full label: the stored raw label (possibly multi-line), or the folder's sanitized one
+func snapshotLabel(snapshotsRoot, folder string) string {
+	if meta, ok := loadSnapshotMeta(filepath.Join(snapshotsRoot, folder)); ok && meta.Label != "" {
+		return meta.Label
+	}
+	return snapshotFolderLabel(folder)
+}
+
+// The label to show for a snapshot on one line: the first line of its full label
+func snapshotDisplayLabel(snapshotsRoot, folder string) string {
+	return strings.SplitN(snapshotLabel(snapshotsRoot, folder), "\n", 2)[0]
+}
+
+// Empty directories to recreate on restore: from metadata, or found by walking older snapshots
+func snapshotEmptyDirs(snapshotPath string, ignoreSet *IgnoreSet) []string {
+	var dirs []string
+	if meta, ok := loadSnapshotMeta(snapshotPath); ok {
+		dirs = meta.EmptyDirs
+	} else {
+		filepath.Walk(snapshotPath, func(path string, info os.FileInfo, err error) error {
+			if err != nil || !info.IsDir() || path == snapshotPath {
+				return nil
+			}
+			if entries, err := os.ReadDir(path); err == nil && len(entries) == 0 {
+				if relPath, err := filepath.Rel(snapshotPath, path); err == nil {
+					dirs = append(dirs, filepath.ToSlash(relPath))
+				}
+			}
+			return nil
+		})
+	}
+	
+	var kept []string
+	for _, dir := range dirs {
+		if !isIgnored(dir, ignoreSet) {
+			kept = append(kept, dir)
+		}
+	}
+	return kept
+}
+
+// Read the project's git HEAD and dirty state; ok is false when git or the repo is unavailable
+func gitState(projectRoot string) (string, bool, bool) {
+	headCmd := exec.Command("git", "rev-parse", "HEAD")
+	headCmd.Dir = projectRoot
+	head, err := headCmd.Output()
+	if err != nil {
+		return "", false, false
+	}
+	
+	statusCmd := exec.Command("git", "status", "--porcelain")
+	statusCmd.Dir = projectRoot
+	status, err := statusCmd.Output()
+	if err != nil {
+		return "", false, false
+	}
+	return strings.TrimSpace(string(head)), len(strings.TrimSpace(string(status))) > 0, true
+}
+
+// Describe the git state recorded for a snapshot, e.g. "commit abc1234, working tree dirty"
+func snapshotGitNote(snapshotsRoot, folder string) string {
+	meta, ok := loadSnapshotMeta(filepath.Join(snapshotsRoot, folder))
+	if !ok || meta.GitCommit == "" {
+		return ""
+	}
+	commit := meta.GitCommit
+	if len(commit) > 7 {
+		commit = commit[:7]
+	}
+	if meta.GitDirty {
+		return "commit " + commit + ", working tree dirty"
+	}
+	return "commit " + commit + ", working tree clean"
+}
+
+// When a snapshot was created: the metadata timestamp, else the directory's modification time
+func snapshotCreatedAt(snapshotsRoot, folder string) (time.Time, error) {
+	if meta, ok := loadSnapshotMeta(filepath.Join(snapshotsRoot, folder)); ok && !meta.CreatedAt.IsZero() {
+		return meta.CreatedAt, nil
+	}
+	info, err := os.Stat(filepath.Join(snapshotsRoot, folder))
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+// Check whether a creation time falls inside the --since/--before window (zero disables a bound)
+func withinAgeWindow(created, now time.Time, since, before time.Duration) bool {
+	if since > 0 && created.Before(now.Add(-since)) {
+		return false
+	}
+	if before > 0 && !created.Before(now.Add(-before)) {
+		return false
+	}
+	return true
+}
+
+// Print all snapshots, optionally limited to a creation-time window
+func (e *engine) listSnapshots(snapshotsRoot string, since, before time.Duration) {
+	now := time.Now()
+	var lines []string
+	indices := listSnapshotIndices(snapshotsRoot)
+	
+	// Keep columns aligned once indices grow past SNAPSHOT_INDEX_WIDTH digits
+	indexWidth := SNAPSHOT_INDEX_WIDTH
+	if len(indices) > 0 {
+		indexWidth = len(padNumber(indices[len(indices)-1], SNAPSHOT_INDEX_WIDTH))
+	}
+	for _, index := range indices {
+		folder := findSnapshotByIndex(snapshotsRoot, index)
+		created, err := snapshotCreatedAt(snapshotsRoot, folder)
+		if err != nil || !withinAgeWindow(created, now, since, before) {
+			continue
+		}
+		paddedIndex := padNumber(index, indexWidth)
+		line := fmt.Sprintf("  %s  %-40s %s  (%s)", paddedIndex, snapshotDisplayLabel(snapshotsRoot, folder), created.Format("2006-01-02 15:04"), formatAge(created))
+		if gitNote := snapshotGitNote(snapshotsRoot, folder); gitNote != "" {
+			line += "  [" + gitNote + "]"
+		}
+		if meta, ok := loadSnapshotMeta(filepath.Join(snapshotsRoot, folder)); ok && meta.Parent != 0 {
+			line += "  (incremental on " + padNumber(meta.Parent, SNAPSHOT_INDEX_WIDTH) + ")"
+		}
+		lines = append(lines, line)
+	}
+	
+	if len(lines) == 0 {
+		fmt.Fprintln(e.out, "📭 No snapshots match.")
+		return
+	}
+	fmt.Fprintf(e.out, "📚 Snapshots (%d):\n", len(lines))
+	fmt.Fprintln(e.out, strings.Join(lines, "\n"))
+}
+
+// Find snapshots whose label contains the query (case-insensitive unless --exact-case), in index order
+func (e *engine) findSnapshotsByLabel(snapshotsRoot, query string) []int {
+	var matches []int
+	for _, index := range listSnapshotIndices(snapshotsRoot) {
+		folder := findSnapshotByIndex(snapshotsRoot, index)
+		if e.labelMatches(snapshotDisplayLabel(snapshotsRoot, folder), query, true) {
+			matches = append(matches, index)
+		}
+	}
+	return matches
+}
+
+// Compare a label with a search term, ignoring case unless --exact-case. With partial the term may
+// appear anywhere in the label; otherwise it must be the whole label.
+func (e *engine) labelMatches(label, term string, partial bool) bool {
+	if !e.exactCase {
+		label, term = strings.ToLower(label), strings.ToLower(term)
+	}
+	if partial {
+		return strings.Contains(label, term)
+	}
+	return label == term
+}
+
+// Parse the answer to the snapshot selection prompt (empty means the newest listed)
+func parseSnapshotSelection(answer string, indices []int) (int, error) {
+	if answer == "" {
+		return indices[0], nil
+	}
+	
+	selected, err := strconv.Atoi(answer)
+	if err != nil {
+		return 0, fmt.Errorf("%s is not a number", answer)
+	}
+	for _, index := range indices {
+		if index == selected {
+			return selected, nil
+		}
+	}
+	return 0, fmt.Errorf("snapshot %d is not in the list", selected)
+}
+
+// List recent snapshots and ask the user to pick one
+func (e *engine) selectSnapshotInteractively(snapshotsRoot string) (int, error) {
+	indices := listSnapshotIndices(snapshotsRoot)
+	if len(indices) == 0 {
+		return 0, fmt.Errorf("no snapshots exist yet")
+	}
+	
+	// Show the most recent snapshots first
+	var recent []int
+	for i := len(indices) - 1; i >= 0 && len(recent) < 10; i-- {
+		recent = append(recent, indices[i])
+	}
+	
+	fmt.Fprintln(e.out, "📚 Recent snapshots:")
+	for _, index := range recent {
+		folder := findSnapshotByIndex(snapshotsRoot, index)
+		paddedIndex := padNumber(index, SNAPSHOT_INDEX_WIDTH)
+		age := ""
+		if created, err := snapshotCreatedAt(snapshotsRoot, folder); err == nil {
+			age = formatAge(created)
+		}
+		fmt.Fprintf(e.out, "  %s  %-40s %s\n", paddedIndex, snapshotDisplayLabel(snapshotsRoot, folder), age)
+	}
+	fmt.Fprintln(e.out, "")
+	
+	for {
+		answer, err := e.askUser(fmt.Sprintf("   Select a snapshot number (Enter for %s): ", padNumber(recent[0], SNAPSHOT_INDEX_WIDTH)))
+		if err != nil {
+			return 0, err
+		}
+		index, err := parseSnapshotSelection(answer, recent)
+		if err == nil {
+			fmt.Fprintln(e.out, "")
+			return index, nil
+		}
+		fmt.Fprintf(e.out, "   %v. Please try again.\n", err)
+	}
+}
+
+// Resolve a snapshot reference: a number, "latest", "-N" (N snapshots before latest), or a label.
+// Labels must match a snapshot's whole label or folder label, ignoring case unless --exact-case.
+func (e *engine) resolveSnapshot(snapshotsRoot, ref string) (int, error) {
+	if ref != "latest" && !strings.HasPrefix(ref, "-") {
+		if index, err := strconv.Atoi(ref); err == nil {
+			return index, nil
+		}
+		var matches []string
+		var index int
+		for _, candidate := range listSnapshotIndices(snapshotsRoot) {
+			folder := findSnapshotByIndex(snapshotsRoot, candidate)
+			if e.labelMatches(snapshotDisplayLabel(snapshotsRoot, folder), ref, false) || e.labelMatches(snapshotFolderLabel(folder), ref, false) {
+				matches = append(matches, padNumber(candidate, SNAPSHOT_INDEX_WIDTH))
+				index = candidate
+			}
+		}
+		switch len(matches) {
+		case 0:
+			return 0, fmt.Errorf("%s is not a snapshot number, \"latest\", -N, or a snapshot label", ref)
+		case 1:
+			return index, nil
+		default:
+			return 0, fmt.Errorf("label %q matches snapshots %s; use a number instead", ref, strings.Join(matches, ", "))
+		}
+	}
+	
+	indices := listSnapshotIndices(snapshotsRoot)
+	if len(indices) == 0 {
+		return 0, fmt.Errorf("no snapshots exist yet")
+	}
+	if ref == "latest" {
+		return indices[len(indices)-1], nil
+	}
+	
+	offset, err := strconv.Atoi(strings.TrimPrefix(ref, "-"))
+	if err != nil || offset < 0 {
+		return 0, fmt.Errorf("%s is not a valid relative offset", ref)
+	}
+	if offset >= len(indices) {
+		return 0, fmt.Errorf("%s is out of range: only %d snapshot(s) exist", ref, len(indices))
+	}
+	return indices[len(indices)-1-offset], nil
+}
\ No newline at end of file
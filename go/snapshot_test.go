@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+	
+	"snapshot/pkg/snapshot"
+)
+
+func TestParseArgs(t *testing.T) {
+	depth, limit := 2, 0
+	tests := []struct {
+		name string
+		args []string
+		want snapshot.Flags
+	}{
+		{"no arguments shows help", nil, snapshot.Flags{Help: true}},
+		{"list window", []string{"--list", "--since", "2d", "--before", "1h"}, snapshot.Flags{List: true, Since: "2d", Before: "1h"}},
+		{"diff of a snapshot", []string{"12", "--diff", "--stat"}, snapshot.Flags{Args: []string{"12"}, Diff: true, Stat: true}},
+		{"bounded restore", []string{"3", "--restore", "--concurrency", "2"}, snapshot.Flags{Args: []string{"3"}, Restore: true, Concurrency: 2}},
+		{"diff against a directory", []string{"12", "--diff", "--against", "../other"}, snapshot.Flags{Args: []string{"12"}, Diff: true, Against: "../other"}},
+		{"snapshot past .gitignore", []string{"--no-gitignore"}, snapshot.Flags{NoGitignore: true}},
+		{"follow directory links", []string{"--follow-symlinks"}, snapshot.Flags{FollowSymlinks: true}},
+		{"prune by age", []string{"prune", "--keep", "5", "--older-than", "30d", "--dry-run"}, snapshot.Flags{Args: []string{"prune"}, Keep: 5, OlderThan: "30d", DryRun: true}},
+		{"regression between two snapshots", []string{"10", "14", "--analyze-regression"}, snapshot.Flags{Args: []string{"10", "14"}, AnalyzeRegression: true}},
+		{"full manifest", []string{"big refactor", "--manifest-limit", "0"}, snapshot.Flags{Args: []string{"big refactor"}, ManifestLimit: &limit}},
+		{"index-only diff", []string{"1", "2", "--diff", "--compare-manifest"}, snapshot.Flags{Args: []string{"1", "2"}, Diff: true, DiffOptions: snapshot.DiffOptions{CompareManifest: true}}},
+		{"pinned snapshot index", []string{"fixture", "--force-index", "42", "--force"}, snapshot.Flags{Args: []string{"fixture"}, ForceIndex: 42, Force: true}},
+		{"subtree snapshot", []string{"auth", "--path", "services/auth"}, snapshot.Flags{Args: []string{"auth"}, Path: "services/auth"}},
+		{"description from stdin", []string{"--stdin-label"}, snapshot.Flags{StdinLabel: true}},
+		{"compressed reports", []string{"3", "--diff", "--gzip-artifacts"}, snapshot.Flags{Args: []string{"3"}, Diff: true, GzipArtifacts: true}},
+		{"escaped init label", []string{"--", "init"}, snapshot.Flags{EscapedLabel: []string{"init"}}},
+		{"flags before the escape", []string{"--dry-run", "--", "log", "--quiet"}, snapshot.Flags{DryRun: true, EscapedLabel: []string{"log", "--quiet"}}},
+		{"init command", []string{"init"}, snapshot.Flags{Args: []string{"init"}}},
+		{"status filter", []string{"4", "--diff", "--only-status", "A,M", "--only-status", "removed"}, snapshot.Flags{Args: []string{"4"}, Diff: true, OnlyStatus: []string{"A,M", "removed"}}},
+		{"restore preview with diffs", []string{"3", "--restore", "--dry-run", "--show-diff"}, snapshot.Flags{Args: []string{"3"}, Restore: true, DryRun: true, ShowDiff: true}},
+		{"secret scan", []string{"wip", "--scan-secrets"}, snapshot.Flags{Args: []string{"wip"}, ScanSecrets: true}},
+		{"redacted prompt", []string{"7", "--prompt", "--redact"}, snapshot.Flags{Args: []string{"7"}, Prompt: true, Redact: true}},
+		{"encrypted snapshot", []string{"private", "--encrypt"}, snapshot.Flags{Args: []string{"private"}, Encrypt: true}},
+		{"streamed diff", []string{"2", "--diff", "--json-lines"}, snapshot.Flags{Args: []string{"2"}, Diff: true, JSONLines: true}},
+		{"case-sensitive labels", []string{"Login Fix", "--diff", "--exact-case"}, snapshot.Flags{Args: []string{"Login Fix"}, Diff: true, ExactCase: true}},
+		{"restore preview as JSON", []string{"3", "--restore", "--preview-json", "--clean"}, snapshot.Flags{Args: []string{"3"}, Restore: true, PreviewJSON: true, Clean: true}},
+		{"shallow snapshot", []string{"top", "--max-depth", "2"}, snapshot.Flags{Args: []string{"top"}, MaxDepth: &depth}},
+		{"line-ending-insensitive diff", []string{"--diff", "--ignore-eol"}, snapshot.Flags{Diff: true, DiffOptions: snapshot.DiffOptions{IgnoreEOL: true}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseArgs(tt.args); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseArgs(%q) = %+v, want %+v", tt.args, got, tt.want)
+			}
+		})
+	}
+}
+
+// A value flag at the end of the line exits with a usage error; run it in a child process
+func TestParseArgsMissingValue(t *testing.T) {
+	if args := os.Getenv("SNAPSHOT_TEST_PARSE_ARGS"); args != "" {
+		parseArgs(strings.Fields(args))
+		os.Exit(0)
+	}
+	
+	for _, args := range []string{"--list --since", "--list --before", "--keep", "--max-depth"} {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestParseArgsMissingValue$")
+		cmd.Env = append(os.Environ(), "SNAPSHOT_TEST_PARSE_ARGS="+args)
+		output, err := cmd.CombinedOutput()
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok || exitErr.ExitCode() != 1 {
+			t.Errorf("%q: want exit 1, got %v", args, err)
+			continue
+		}
+		flag := args[strings.LastIndex(args, " ")+1:]
+		if !strings.Contains(string(output), "❌ "+flag+" needs a value") || !strings.Contains(string(output), "USAGE:") {
+			t.Errorf("%q: want a usage error, got:\n%s", args, output)
+		}
+	}
+}
\ No newline at end of file